@@ -0,0 +1,11 @@
+package main
+
+import (
+	"github.com/zasuchilas/gophermart/internal/gophermart/ctl"
+	"os"
+)
+
+func main() {
+	c := ctl.New()
+	os.Exit(c.Run(os.Args[1:]))
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/zasuchilas/gophermart/internal/accrual/storage"
 	"github.com/zasuchilas/gophermart/internal/accrual/storage/pgstorage"
 	"github.com/zasuchilas/gophermart/internal/accrual/worker"
+	"github.com/zasuchilas/gophermart/internal/common"
 	"go.uber.org/zap"
 	"os"
 	"os/signal"
@@ -38,6 +39,9 @@ func New() *App {
 func (a *App) Run() {
 	logger.Init()
 	logger.ServiceInfo("ACCRUAL.GOPHERMART (... service)", a.AppVersion)
+	if !common.ValidCurrency(config.Currency) {
+		logger.Log.Fatal("unknown currency", zap.String("currency", config.Currency))
+	}
 	a.store = pgstorage.New()
 
 	a.server = chisrv.New(a.store, a.waitGroup)
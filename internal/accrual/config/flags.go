@@ -2,17 +2,22 @@ package config
 
 import (
 	"flag"
+	"github.com/zasuchilas/gophermart/internal/common"
 	"github.com/zasuchilas/gophermart/pkg/envflags"
+	"github.com/zasuchilas/gophermart/pkg/httperr"
 	"time"
 )
 
 var (
-	RunAddress      string
-	DatabaseURI     string
-	LogLevel        string
-	EnvType         string
-	WorkerPeriod    time.Duration
-	WorkerPackLimit int
+	RunAddress        string
+	DatabaseURI       string
+	LogLevel          string
+	EnvType           string
+	WorkerPeriod      time.Duration
+	WorkerPackLimit   int
+	Currency          string
+	MaxBodySize       int
+	OpenAPIValidation bool
 )
 
 func ParseFlags() {
@@ -22,6 +27,9 @@ func ParseFlags() {
 	flag.StringVar(&EnvType, "e", "production", "type of environment (production or develop)")
 	flag.DurationVar(&WorkerPeriod, "w", 3*time.Second, "calculate accrual worker period")
 	flag.IntVar(&WorkerPackLimit, "p", 25, "calculate accrual worker pack limit")
+	flag.StringVar(&Currency, "currency", common.DefaultCurrency, "currency of the orders and the goods registered without one")
+	flag.IntVar(&MaxBodySize, "max-body-size", httperr.DefaultMaxBodySize, "maximum size of a request body in bytes")
+	flag.BoolVar(&OpenAPIValidation, "openapi-validation", false, "reject the requests not matching the OpenAPI document")
 	flag.Parse()
 
 	envflags.TryUseEnvString(&RunAddress, "RUN_ADDRESS")
@@ -30,4 +38,7 @@ func ParseFlags() {
 	envflags.TryUseEnvString(&EnvType, "ENV_TYPE")
 	envflags.TryUseEnvDuration(&WorkerPeriod, "WORKER_PERIOD")
 	envflags.TryUseEnvInt(&WorkerPackLimit, "WORKER_PACK_LIMIT")
+	envflags.TryUseEnvString(&Currency, "CURRENCY")
+	envflags.TryUseEnvInt(&MaxBodySize, "MAX_BODY_SIZE")
+	envflags.TryUseEnvBool(&OpenAPIValidation, "OPENAPI_VALIDATION")
 }
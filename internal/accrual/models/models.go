@@ -2,29 +2,33 @@ package models
 
 import (
 	"github.com/Rhymond/go-money"
+	"github.com/zasuchilas/gophermart/pkg/amount"
 	"time"
 )
 
 type GoodsData struct {
-	Match      string  `json:"match"`
-	Reward     float64 `json:"reward"`
-	RewardType string  `json:"reward_type"`
+	Match      string        `json:"match"`
+	Reward     amount.Amount `json:"reward"` // percent or points
+	RewardType string        `json:"reward_type"`
+	Currency   string        `json:"currency,omitempty"` // the currency of points, the program currency if omitted
 }
 
 type Receipt struct {
-	Order string `json:"order"`
-	Goods []GoodsPosition
+	Order    string `json:"order"`
+	Currency string `json:"currency,omitempty"` // the program currency if omitted
+	Goods    []GoodsPosition
 }
 
 type GoodsPosition struct {
-	Description string  `json:"description"`
-	Price       float64 `json:"price"`
+	Description string        `json:"description"`
+	Price       amount.Amount `json:"price"`
 }
 
 type OrderData struct {
-	Order   string  `json:"order"`
-	Status  string  `json:"status"`
-	Accrual float64 `json:"accrual"`
+	Order    string        `json:"order"`
+	Status   string        `json:"status"`
+	Accrual  amount.Amount `json:"accrual"`
+	Currency string        `json:"currency"`
 }
 
 type AccrualOrder struct {
@@ -32,6 +36,7 @@ type AccrualOrder struct {
 	OrderNum   string
 	Status     string
 	Accrual    *money.Money
+	Currency   string
 	Receipt    *Receipt
 	UploadedAt time.Time
 }
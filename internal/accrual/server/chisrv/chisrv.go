@@ -6,6 +6,8 @@ import (
 	"github.com/zasuchilas/gophermart/internal/accrual/config"
 	"github.com/zasuchilas/gophermart/internal/accrual/logger"
 	"github.com/zasuchilas/gophermart/internal/accrual/storage"
+	"github.com/zasuchilas/gophermart/pkg/amount"
+	"github.com/zasuchilas/gophermart/pkg/httperr"
 	"go.uber.org/zap"
 	"net/http"
 	"sync"
@@ -38,17 +40,27 @@ func (s *ChiServer) router() chi.Router {
 	r := chi.NewRouter()
 
 	// middlewares
+	r.Use(httperr.RequestID)
 	r.Use(middleware.Logger)
+	r.Use(httperr.LimitBody(int64(config.MaxBodySize)))
 	r.Use(middleware.AllowContentEncoding("deflate", "gzip"))
 	r.Use(middleware.Compress(9, "application/json", "text/plain")) // if Accept-Encoding header (gzip, deflate)
+	r.Use(amount.AsStrings)                                         // if Accept: application/json; amounts=string
+	if config.OpenAPIValidation {
+		r.Use(apiSpec.Validate)
+	}
+
+	r.NotFound(httperr.NotFound)
+	r.MethodNotAllowed(httperr.MethodNotAllowed)
 
 	// routes
 	r.Get("/", s.home)
+	r.Get("/openapi.json", apiSpec.ServeHTTP)
 	r.Post("/api/orders", s.registerOrder)
 	r.Post("/api/goods", s.registerGoods)
 
 	r.Group(func(r chi.Router) {
-		r.Use(middleware.Throttle(15))
+		r.Use(throttle(15))
 		r.Get("/api/orders/{orderNum}", s.getOrderAccrual)
 	})
 
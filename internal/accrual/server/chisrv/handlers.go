@@ -6,13 +6,24 @@ import (
 	"errors"
 	"github.com/go-chi/chi/v5"
 	"github.com/theplant/luhn"
+	"github.com/zasuchilas/gophermart/internal/accrual/config"
 	"github.com/zasuchilas/gophermart/internal/accrual/logger"
 	"github.com/zasuchilas/gophermart/internal/accrual/models"
+	"github.com/zasuchilas/gophermart/internal/common"
+	"github.com/zasuchilas/gophermart/pkg/httperr"
 	"go.uber.org/zap"
 	"net/http"
 	"strconv"
 )
 
+// The codes of the accrual errors, the generic ones are in httperr
+const (
+	codeInvalidOrderNumber = "invalid_order_number"
+	codeUnknownCurrency    = "unknown_currency"
+	codeOrderRegistered    = "order_registered"
+	codeGoodsRegistered    = "goods_registered"
+)
+
 func (s *ChiServer) home(w http.ResponseWriter, _ *http.Request) {
 	w.Header().Set("Content-Type", "text/plain")
 	w.WriteHeader(http.StatusOK)
@@ -48,7 +59,7 @@ func (s *ChiServer) getOrderAccrual(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		logger.Log.Info("cannot get order data from db", zap.String("error", err.Error()))
-		w.WriteHeader(http.StatusInternalServerError)
+		httperr.Status(w, r, http.StatusInternalServerError)
 		return
 	}
 
@@ -65,23 +76,22 @@ func (s *ChiServer) registerOrder(w http.ResponseWriter, r *http.Request) {
 
 	// decoding request
 	var req models.Receipt
-	dec := json.NewDecoder(r.Body)
-	if err := dec.Decode(&req); err != nil {
-		logger.Log.Info("cannot decode request JSON body", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
+	if err := httperr.Decode(r, &req); err != nil {
+		httperr.WriteError(w, r, err)
 		return
 	}
 	// getting receipt
 	receipt, err := json.Marshal(req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logger.Log.Error("cannot encode receipt", zap.String("error", err.Error()))
+		httperr.Status(w, r, http.StatusInternalServerError)
 		return
 	}
 
 	// validation
 	orderNum := req.Order
 	if orderNum == "" {
-		http.Error(w, "the order number is required", http.StatusBadRequest)
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, "the order number is required")
 		return
 	}
 
@@ -90,23 +100,31 @@ func (s *ChiServer) registerOrder(w http.ResponseWriter, r *http.Request) {
 	// https://goodcalculators.com/luhn-algorithm-calculator/?Num=18
 	number, err := strconv.Atoi(orderNum)
 	if err != nil {
-		http.Error(w, "the order number must be a number", http.StatusBadRequest)
+		httperr.Write(w, r, http.StatusBadRequest, codeInvalidOrderNumber, "the order number must be a number")
 		return
 	}
 	if ok := luhn.Valid(number); !ok {
-		http.Error(w, "luna validation failed", http.StatusBadRequest)
+		httperr.Write(w, r, http.StatusBadRequest, codeInvalidOrderNumber, "luna validation failed")
+		return
+	}
+	currency := config.Currency
+	if req.Currency != "" {
+		currency = req.Currency
+	}
+	if !common.ValidCurrency(currency) {
+		httperr.Write(w, r, http.StatusBadRequest, codeUnknownCurrency, "unknown currency "+currency)
 		return
 	}
 
 	// writing into db
-	id, err := s.store.RegisterNewOrder(r.Context(), orderNum, string(receipt))
+	id, err := s.store.RegisterNewOrder(r.Context(), orderNum, currency, string(receipt))
 	if id == 0 {
-		w.WriteHeader(http.StatusConflict)
+		httperr.Write(w, r, http.StatusConflict, codeOrderRegistered, "the order is already registered")
 		return
 	}
 	if err != nil {
 		logger.Log.Error("failed to write new order into db", zap.String("error", err.Error()))
-		w.WriteHeader(http.StatusInternalServerError)
+		httperr.Status(w, r, http.StatusInternalServerError)
 		return
 	}
 
@@ -117,36 +135,42 @@ func (s *ChiServer) registerGoods(w http.ResponseWriter, r *http.Request) {
 
 	// decoding request
 	var req models.GoodsData
-	dec := json.NewDecoder(r.Body)
-	if err := dec.Decode(&req); err != nil {
-		logger.Log.Info("cannot decode request JSON body", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
+	if err := httperr.Decode(r, &req); err != nil {
+		httperr.WriteError(w, r, err)
 		return
 	}
 
 	// validation
 	if len(req.Match) < 3 {
-		http.Error(w, "the match cannot be less than three characters", http.StatusBadRequest)
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, "the match cannot be less than three characters")
 		return
 	}
 	if req.RewardType != "%" && req.RewardType != "pt" {
-		http.Error(w, "the reward_type must be '%' or 'pt'", http.StatusBadRequest)
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, "the reward_type must be '%' or 'pt'")
 		return
 	}
 	if req.Reward < 0 {
-		http.Error(w, "the reward cannot be less than zero", http.StatusBadRequest)
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, "the reward cannot be less than zero")
+		return
+	}
+	currency := config.Currency
+	if req.Currency != "" {
+		currency = req.Currency
+	}
+	if !common.ValidCurrency(currency) {
+		httperr.Write(w, r, http.StatusBadRequest, codeUnknownCurrency, "unknown currency "+currency)
 		return
 	}
 
 	// write into db
-	id, err := s.store.RegisterNewGoods(r.Context(), req.Match, req.RewardType, req.Reward)
+	id, err := s.store.RegisterNewGoods(r.Context(), req.Match, req.RewardType, req.Reward, currency)
 	if id == 0 {
-		w.WriteHeader(http.StatusConflict)
+		httperr.Write(w, r, http.StatusConflict, codeGoodsRegistered, "the goods match is already registered")
 		return
 	}
 	if err != nil {
 		logger.Log.Info("failed to write new goods into db", zap.String("error", err.Error()))
-		w.WriteHeader(http.StatusInternalServerError)
+		httperr.Status(w, r, http.StatusInternalServerError)
 		return
 	}
 
@@ -0,0 +1,13 @@
+package chisrv
+
+import (
+	_ "embed"
+	"github.com/zasuchilas/gophermart/pkg/openapi"
+)
+
+// openapi.json documents the routes of the router, TestRoutesMatchSpec keeps them in sync
+
+//go:embed openapi.json
+var openapiDocument []byte
+
+var apiSpec = openapi.MustParse(openapiDocument)
@@ -0,0 +1,32 @@
+package chisrv
+
+import (
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestRoutesMatchSpec(t *testing.T) {
+	s := &ChiServer{}
+	var routed []string
+	err := chi.Walk(s.router(), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		routed = append(routed, method+" "+route)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walking the router: %v", err)
+	}
+	documented := apiSpec.Routes()
+
+	for _, route := range routed {
+		if !slices.Contains(documented, route) {
+			t.Errorf("%s is routed but not documented in openapi.json", route)
+		}
+	}
+	for _, route := range documented {
+		if !slices.Contains(routed, route) {
+			t.Errorf("%s is documented in openapi.json but not routed", route)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package chisrv
+
+import (
+	"github.com/zasuchilas/gophermart/pkg/httperr"
+	"net/http"
+	"strconv"
+)
+
+// throttle is middleware.Throttle without the backlog responding with the error envelope,
+// the gophermart worker pauses on 429
+func throttle(limit int) func(http.Handler) http.Handler {
+	tokens := make(chan struct{}, limit)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case tokens <- struct{}{}:
+				defer func() { <-tokens }()
+				next.ServeHTTP(w, r)
+			default:
+				httperr.Write(w, r, http.StatusTooManyRequests, httperr.CodeTooManyRequests,
+					"no more than "+strconv.Itoa(limit)+" concurrent requests are allowed")
+			}
+		})
+	}
+}
@@ -35,6 +35,19 @@ func createTablesIfNeed(db *sql.DB) {
 		  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
 		  deleted BOOL NOT NULL DEFAULT false
 		);
+		-- the reward is a percent or points with up to 2 fractional digits,
+		-- the column is converted once, the type change rewrites the table under an exclusive lock
+		DO $$
+		BEGIN
+		  IF EXISTS (SELECT 1 FROM information_schema.columns
+		    WHERE table_schema = 'accrual' AND table_name = 'goods' AND column_name = 'reward' AND data_type <> 'numeric') THEN
+		    ALTER TABLE accrual.goods ALTER COLUMN reward TYPE NUMERIC(12, 2);
+		  END IF;
+		END $$;
+
+		-- the orders and the goods registered before the currency appeared are in roubles
+		ALTER TABLE accrual.orders ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'RUB';
+		ALTER TABLE accrual.goods ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'RUB';
 		
   `
 
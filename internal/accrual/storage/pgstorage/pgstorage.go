@@ -12,6 +12,7 @@ import (
 	"github.com/zasuchilas/gophermart/internal/accrual/models"
 	"github.com/zasuchilas/gophermart/internal/accrual/storage"
 	"github.com/zasuchilas/gophermart/internal/common"
+	"github.com/zasuchilas/gophermart/pkg/amount"
 	"go.uber.org/zap"
 	"time"
 )
@@ -49,22 +50,22 @@ func (d *PgStorage) InstanceName() string {
 	return storage.InstancePostgresql
 }
 
-func (d *PgStorage) RegisterNewGoods(ctx context.Context, match, rewardType string, reward float64) (int64, error) {
+func (d *PgStorage) RegisterNewGoods(ctx context.Context, match, rewardType string, reward amount.Amount, currency string) (int64, error) {
 	var id int64
 	err := d.db.QueryRowContext(
 		ctx,
-		"INSERT INTO accrual.goods (match, reward, reward_type) VALUES($1, $2, $3) ON CONFLICT DO NOTHING RETURNING id",
-		match, reward, rewardType,
+		"INSERT INTO accrual.goods (match, reward, reward_type, currency) VALUES($1, $2, $3, $4) ON CONFLICT DO NOTHING RETURNING id",
+		match, reward.String(), rewardType, currency,
 	).Scan(&id)
 	return id, err
 }
 
-func (d *PgStorage) RegisterNewOrder(ctx context.Context, orderNum string, receipt string) (int64, error) {
+func (d *PgStorage) RegisterNewOrder(ctx context.Context, orderNum, currency string, receipt string) (int64, error) {
 	var id int64
 	err := d.db.QueryRowContext(
 		ctx,
-		"INSERT INTO accrual.orders (order_num, receipt) VALUES($1, $2) ON CONFLICT DO NOTHING RETURNING id",
-		orderNum, receipt,
+		"INSERT INTO accrual.orders (order_num, currency, receipt) VALUES($1, $2, $3) ON CONFLICT DO NOTHING RETURNING id",
+		orderNum, currency, receipt,
 	).Scan(&id)
 	return id, err
 }
@@ -75,10 +76,10 @@ func (d *PgStorage) GetOrderData(ctx context.Context, orderNum string) (*models.
 		accrual int64
 	)
 	err := d.db.QueryRowContext(ctx,
-		"SELECT order_num, status, accrual FROM accrual.orders WHERE order_num = $1",
-		orderNum).Scan(&v.Order, &v.Status, &accrual)
+		"SELECT order_num, status, accrual, currency FROM accrual.orders WHERE order_num = $1",
+		orderNum).Scan(&v.Order, &v.Status, &accrual, &v.Currency)
 
-	v.Accrual = money.New(accrual, money.RUB).AsMajorUnits()
+	v.Accrual = amount.Amount(accrual)
 
 	return &v, err
 }
@@ -88,7 +89,7 @@ func (d *PgStorage) GetGoods(ctx context.Context) ([]*models.GoodsData, error) {
 	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	stmt, err := d.db.PrepareContext(ctxTm, `SELECT match, reward, reward_type FROM accrual.goods WHERE deleted = false`)
+	stmt, err := d.db.PrepareContext(ctxTm, `SELECT match, reward, reward_type, currency FROM accrual.goods WHERE deleted = false`)
 	if err != nil {
 		return nil, err
 	}
@@ -106,11 +107,18 @@ func (d *PgStorage) GetGoods(ctx context.Context) ([]*models.GoodsData, error) {
 
 		goods := make([]*models.GoodsData, 0)
 		for rows.Next() {
-			var gd models.GoodsData
-			err = rows.Scan(&gd.Match, &gd.Reward, &gd.RewardType)
+			var (
+				gd     models.GoodsData
+				reward string
+			)
+			err = rows.Scan(&gd.Match, &reward, &gd.RewardType, &gd.Currency)
 			if err != nil {
 				return nil, err
 			}
+			gd.Reward, err = amount.Parse(reward)
+			if err != nil {
+				return nil, fmt.Errorf("goods %s reward %s: %w", gd.Match, reward, err)
+			}
 			goods = append(goods, &gd)
 		}
 
@@ -135,7 +143,7 @@ func (d *PgStorage) GetOrders(ctx context.Context) ([]*models.AccrualOrder, erro
 	defer cancel()
 
 	stmt, err := d.db.PrepareContext(ctxTm,
-		`SELECT id, order_num, status, accrual, receipt, uploaded_at FROM accrual.orders WHERE status = any($1) LIMIT $2`)
+		`SELECT id, order_num, status, accrual, currency, receipt, uploaded_at FROM accrual.orders WHERE status = any($1) LIMIT $2`)
 	if err != nil {
 		return nil, err
 	}
@@ -159,7 +167,7 @@ func (d *PgStorage) GetOrders(ctx context.Context) ([]*models.AccrualOrder, erro
 				receipt string
 				rc      models.Receipt
 			)
-			err = rows.Scan(&ord.ID, &ord.OrderNum, &ord.Status, &accrual, &receipt, &ord.UploadedAt)
+			err = rows.Scan(&ord.ID, &ord.OrderNum, &ord.Status, &accrual, &ord.Currency, &receipt, &ord.UploadedAt)
 			if err != nil {
 				return nil, err
 			}
@@ -167,7 +175,7 @@ func (d *PgStorage) GetOrders(ctx context.Context) ([]*models.AccrualOrder, erro
 			if err == nil {
 				ord.Receipt = &rc
 			}
-			ord.Accrual = money.New(accrual, money.RUB)
+			ord.Accrual = money.New(accrual, ord.Currency)
 			orders = append(orders, &ord)
 		}
 
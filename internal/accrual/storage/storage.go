@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/Rhymond/go-money"
 	"github.com/zasuchilas/gophermart/internal/accrual/models"
+	"github.com/zasuchilas/gophermart/pkg/amount"
 )
 
 const (
@@ -14,8 +15,8 @@ type Storage interface {
 	Stop()
 	InstanceName() string
 
-	RegisterNewGoods(ctx context.Context, match, rewardType string, reward float64) (int64, error)
-	RegisterNewOrder(ctx context.Context, orderNum string, receipt string) (int64, error)
+	RegisterNewGoods(ctx context.Context, match, rewardType string, reward amount.Amount, currency string) (int64, error)
+	RegisterNewOrder(ctx context.Context, orderNum, currency string, receipt string) (int64, error)
 	GetOrderData(ctx context.Context, orderNum string) (*models.OrderData, error)
 
 	GetGoods(ctx context.Context) ([]*models.GoodsData, error)
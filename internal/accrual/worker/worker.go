@@ -9,8 +9,8 @@ import (
 	"github.com/zasuchilas/gophermart/internal/accrual/models"
 	"github.com/zasuchilas/gophermart/internal/accrual/storage"
 	"github.com/zasuchilas/gophermart/internal/common"
+	"github.com/zasuchilas/gophermart/pkg/amount"
 	"go.uber.org/zap"
-	"math"
 	"strings"
 	"sync"
 	"time"
@@ -94,12 +94,12 @@ func (w *CalculateAccrualWorker) processing(goods []*models.GoodsData, orders []
 	hasGoodsMechanics := len(goods) > 0
 	for _, order := range orders {
 		var (
-			accrual float64
+			accrual amount.Amount
 			err     error
 		)
 
 		if !hasGoodsMechanics {
-			err = w.store.UpdateOrder(context.TODO(), order.ID, common.OrderStatusInvalid, money.NewFromFloat(0, common.Currency))
+			err = w.store.UpdateOrder(context.TODO(), order.ID, common.OrderStatusInvalid, money.NewFromFloat(0, order.Currency))
 			if err != nil {
 				logger.Log.Info("error updating order",
 					zap.String("order_num", order.OrderNum), zap.String("error", err.Error()))
@@ -108,9 +108,18 @@ func (w *CalculateAccrualWorker) processing(goods []*models.GoodsData, orders []
 		}
 
 		// checking order
+		if order.Receipt == nil {
+			// the receipt could not be decoded
+			err = w.store.UpdateOrder(context.TODO(), order.ID, common.OrderStatusInvalid, money.NewFromFloat(0, order.Currency))
+			if err != nil {
+				logger.Log.Info("error updating order",
+					zap.String("order_num", order.OrderNum), zap.String("error", err.Error()))
+			}
+			continue
+		}
 		goodsList := order.Receipt.Goods
 		if len(goodsList) == 0 {
-			err = w.store.UpdateOrder(context.TODO(), order.ID, common.OrderStatusInvalid, money.NewFromFloat(0, common.Currency))
+			err = w.store.UpdateOrder(context.TODO(), order.ID, common.OrderStatusInvalid, money.NewFromFloat(0, order.Currency))
 			if err != nil {
 				logger.Log.Info("error updating order",
 					zap.String("order_num", order.OrderNum), zap.String("error", err.Error()))
@@ -121,7 +130,7 @@ func (w *CalculateAccrualWorker) processing(goods []*models.GoodsData, orders []
 		// calculating accrual
 	loopPos:
 		for _, position := range goodsList {
-			ac, er := w.accrualOfReceiptPosition(&position, goods)
+			ac, er := w.accrualOfReceiptPosition(&position, goods, order.Currency)
 			if er != nil {
 				err = er
 				break loopPos
@@ -129,7 +138,7 @@ func (w *CalculateAccrualWorker) processing(goods []*models.GoodsData, orders []
 			accrual += ac
 		}
 		if err != nil {
-			err = w.store.UpdateOrder(context.TODO(), order.ID, common.OrderStatusInvalid, money.NewFromFloat(0, common.Currency))
+			err = w.store.UpdateOrder(context.TODO(), order.ID, common.OrderStatusInvalid, money.NewFromFloat(0, order.Currency))
 			if err != nil {
 				logger.Log.Info("error updating order",
 					zap.String("order_num", order.OrderNum), zap.String("error", err.Error()))
@@ -138,7 +147,7 @@ func (w *CalculateAccrualWorker) processing(goods []*models.GoodsData, orders []
 		}
 
 		// updating order
-		err = w.store.UpdateOrder(context.TODO(), order.ID, common.OrderStatusProcessed, money.NewFromFloat(accrual, common.Currency))
+		err = w.store.UpdateOrder(context.TODO(), order.ID, common.OrderStatusProcessed, money.New(accrual.Minor(), order.Currency))
 		if err != nil {
 			logger.Log.Info("error updating order",
 				zap.String("order_num", order.OrderNum), zap.String("error", err.Error()))
@@ -146,9 +155,13 @@ func (w *CalculateAccrualWorker) processing(goods []*models.GoodsData, orders []
 	}
 }
 
-func (w *CalculateAccrualWorker) accrualOfReceiptPosition(pos *models.GoodsPosition, goods []*models.GoodsData) (accrual float64, err error) {
+func (w *CalculateAccrualWorker) accrualOfReceiptPosition(pos *models.GoodsPosition, goods []*models.GoodsData, currency string) (accrual amount.Amount, err error) {
 loop:
 	for _, good := range goods {
+		// the points in another currency are not mixed into the order accrual
+		if good.RewardType == "pt" && good.Currency != currency {
+			continue
+		}
 		if strings.Contains(pos.Description, good.Match) {
 			reward, er := calculateAccrual(good.RewardType, good.Reward, pos.Price)
 			if er != nil {
@@ -164,16 +177,15 @@ loop:
 	return accrual, nil
 }
 
-func calculateAccrual(rewardType string, reward float64, price float64) (accrual float64, err error) {
-	logger.Log.Debug("start", zap.Float64("reward", reward), zap.Float64("price", price))
+func calculateAccrual(rewardType string, reward amount.Amount, price amount.Amount) (accrual amount.Amount, err error) {
+	logger.Log.Debug("start", zap.Stringer("reward", reward), zap.Stringer("price", price))
 	if rewardType == "%" {
-		//price := 14599.50
-		//percent := 5
-		//reward := price / 100 * (float64)(percent)
-		//fmt.Println(math.Round(reward*100) / 100)
-		rew := price / 100 * reward
-		rez := math.Round(rew*100) / 100
-		logger.Log.Debug("end", zap.Float64("accrual_rez", rez))
+		// 5% of 14599.50 is 729.975, rounded to 729.98
+		rez, er := price.MulPercent(reward)
+		if er != nil {
+			return 0, er
+		}
+		logger.Log.Debug("end", zap.Stringer("accrual_rez", rez))
 		return rez, nil
 	}
 	if rewardType == "pt" {
@@ -3,7 +3,8 @@ package common
 import "github.com/Rhymond/go-money"
 
 const (
-	Currency = money.RUB
+	// DefaultCurrency is the currency of accounts and programs created without an explicit one
+	DefaultCurrency = money.RUB
 
 	OrderStatusNew        = "NEW"        // gophermart service
 	OrderStatusRegistered = "REGISTERED" // accrual service
@@ -11,3 +12,10 @@ const (
 	OrderStatusInvalid    = "INVALID"
 	OrderStatusProcessed  = "PROCESSED"
 )
+
+// ValidCurrency reports whether the code is a known ISO 4217 currency
+// with 2 fractional digits (amounts are stored in hundredths).
+func ValidCurrency(code string) bool {
+	c := money.GetCurrency(code)
+	return c != nil && c.Code == code && c.Fraction == 2
+}
@@ -1,13 +1,17 @@
 package gophermart
 
 import (
+	"github.com/zasuchilas/gophermart/internal/common"
 	"github.com/zasuchilas/gophermart/internal/gophermart/config"
+	"github.com/zasuchilas/gophermart/internal/gophermart/events"
 	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
+	"github.com/zasuchilas/gophermart/internal/gophermart/notifier"
 	"github.com/zasuchilas/gophermart/internal/gophermart/server"
 	"github.com/zasuchilas/gophermart/internal/gophermart/server/chisrv"
 	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
 	"github.com/zasuchilas/gophermart/internal/gophermart/storage/pgstorage"
 	"github.com/zasuchilas/gophermart/internal/gophermart/worker"
+	"github.com/zasuchilas/gophermart/pkg/passhash"
 	"go.uber.org/zap"
 	"os"
 	"os/signal"
@@ -22,6 +26,9 @@ type App struct {
 	store      storage.Storage
 	server     server.Server
 	worker     *worker.OrderEnrichWorker
+	webhooks   *worker.WebhookDeliveryWorker
+	holds      *worker.HoldExpiryWorker
+	events     *events.Hub
 }
 
 func New() *App {
@@ -38,10 +45,33 @@ func New() *App {
 func (a *App) Run() {
 	logger.Init()
 	logger.ServiceInfo("GOPHERMART (... service)", a.AppVersion)
+	if !common.ValidCurrency(config.Currency) {
+		logger.Log.Fatal("unknown currency", zap.String("currency", config.Currency))
+	}
+	err := passhash.SetParams(passhash.Params{
+		Algorithm: config.PasswordHash,
+		Time:      uint32(config.Argon2Time),
+		Memory:    uint32(config.Argon2Memory),
+		Threads:   uint8(config.Argon2Threads),
+		Cost:      config.BcryptCost,
+	})
+	if err != nil {
+		logger.Log.Fatal("password hash parameters", zap.Error(err))
+	}
 	a.store = pgstorage.New()
 
-	chisrv.InitJWT()
-	a.server = chisrv.New(a.store, a.waitGroup)
+	a.events = events.New(a.store, a.waitGroup)
+	a.waitGroup.Add(1)
+	go a.events.Start()
+
+	if err := chisrv.InitJWT(); err != nil {
+		logger.Log.Fatal("initializing jwt keys", zap.Error(err))
+	}
+	n, err := notifier.New(config.Notifier, config.NotifierFile)
+	if err != nil {
+		logger.Log.Fatal("initializing notifier", zap.Error(err))
+	}
+	a.server = chisrv.New(a.store, a.events, n, a.waitGroup)
 	a.waitGroup.Add(1)
 	go a.server.Start()
 
@@ -49,6 +79,14 @@ func (a *App) Run() {
 	a.waitGroup.Add(1)
 	go a.worker.Start()
 
+	a.webhooks = worker.NewWebhookDeliveryWorker(a.store, a.waitGroup)
+	a.waitGroup.Add(1)
+	go a.webhooks.Start()
+
+	a.holds = worker.NewHoldExpiryWorker(a.store, a.waitGroup)
+	a.waitGroup.Add(1)
+	go a.holds.Start()
+
 	a.shutdown()
 	a.waitGroup.Wait()
 }
@@ -63,6 +101,9 @@ func (a *App) shutdown() {
 		close(sigChan)
 
 		a.worker.Stop()
+		a.webhooks.Stop()
+		a.holds.Stop()
+		a.events.Stop()
 		a.store.Stop()
 		a.server.Stop()
 
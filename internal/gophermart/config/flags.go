@@ -2,10 +2,15 @@ package config
 
 import (
 	"flag"
+	"github.com/zasuchilas/gophermart/internal/common"
 	"github.com/zasuchilas/gophermart/pkg/envflags"
+	"github.com/zasuchilas/gophermart/pkg/httperr"
 	"time"
 )
 
+// DefaultSecretKey is refused in production
+const DefaultSecretKey = "supersecretkey"
+
 var (
 	RunAddress           string
 	DatabaseURI          string
@@ -16,6 +21,40 @@ var (
 	WorkerPeriod         time.Duration
 	WorkerPackLimit      int
 	WorkerPoolSize       int
+	EventsRetention      time.Duration
+	WebhookPeriod        time.Duration
+	WebhookMaxAttempts   int
+	WebhookAllowPrivate  bool
+	WebhookRetention     time.Duration
+	BatchMaxSize         int
+	MaxBodySize          int
+	OpenAPIValidation    bool
+	IdempotencyRetention time.Duration
+	CancelWindow         time.Duration
+	HoldTTL              time.Duration
+	HoldMaxTTL           time.Duration
+	HoldExpiryPeriod     time.Duration
+	Currency             string
+	AccessTokenTTL       time.Duration
+	RefreshTokenTTL      time.Duration
+	JWTKeys              string
+	JWTActiveKID         string
+	CookieSecure         bool
+	CookieSameSite       string
+	CookieDomain         string
+	CSRFProtection       bool
+	PasswordResetTTL     time.Duration
+	Notifier             string
+	NotifierFile         string
+	LoginMaxFailures     int
+	LoginIPMaxFailures   int
+	LoginDelay           time.Duration
+	LoginLockout         time.Duration
+	PasswordHash         string
+	Argon2Time           int
+	Argon2Memory         int
+	Argon2Threads        int
+	BcryptCost           int
 )
 
 func ParseFlags() {
@@ -24,10 +63,52 @@ func ParseFlags() {
 	flag.StringVar(&AccrualSystemAddress, "r", "localhost:8081", "address of the accrual calculation service")
 	flag.StringVar(&LogLevel, "l", "debug", "logging level")
 	flag.StringVar(&EnvType, "e", "production", "type of environment (production or develop)")
-	flag.StringVar(&SecretKey, "k", "supersecretkey", "the secret key for user tokens")
+	flag.StringVar(&SecretKey, "k", DefaultSecretKey, "the secret key for user tokens (the default is refused with -e production)")
 	flag.DurationVar(&WorkerPeriod, "w", 3*time.Second, "worker period of order enriching worker")
 	flag.IntVar(&WorkerPackLimit, "p", 25, "pack limit of order enriching worker")
 	flag.IntVar(&WorkerPoolSize, "z", 3, "pool size of order enriching worker")
+	flag.DurationVar(&EventsRetention, "events-retention", 24*time.Hour, "how long the user events are kept for replaying")
+	flag.DurationVar(&WebhookPeriod, "webhook-period", 3*time.Second, "worker period of webhook delivery worker")
+	flag.IntVar(&WebhookMaxAttempts, "webhook-max-attempts", 8, "delivery attempts before a webhook delivery fails")
+	flag.BoolVar(&WebhookAllowPrivate, "webhook-allow-private", false, "allow webhook delivery to loopback and private addresses")
+	flag.DurationVar(&WebhookRetention, "webhook-retention", 7*24*time.Hour, "how long the finished webhook deliveries and their attempts are kept")
+	flag.IntVar(&BatchMaxSize, "batch-max-size", 500, "maximum number of orders in a batch upload")
+	flag.IntVar(&MaxBodySize, "max-body-size", httperr.DefaultMaxBodySize, "maximum size of a request body in bytes")
+	flag.BoolVar(&OpenAPIValidation, "openapi-validation", false, "reject the requests not matching the OpenAPI document")
+	flag.DurationVar(&CancelWindow, "cancel-window", 15*time.Minute, "how long the user can cancel a withdrawal")
+	flag.DurationVar(&HoldTTL, "hold-ttl", 15*time.Minute, "default time to live of a balance hold")
+	flag.DurationVar(&HoldMaxTTL, "hold-max-ttl", 24*time.Hour, "maximum time to live of a balance hold")
+	flag.DurationVar(&HoldExpiryPeriod, "hold-expiry-period", 30*time.Second, "worker period of hold expiry worker")
+	flag.DurationVar(&IdempotencyRetention, "idempotency-retention", 24*time.Hour, "how long the responses of idempotent requests are kept")
+	flag.StringVar(&Currency, "currency", common.DefaultCurrency, "currency of the accounts registered without one")
+	flag.DurationVar(&AccessTokenTTL, "access-token-ttl", 15*time.Minute, "time to live of an access token")
+	flag.DurationVar(&RefreshTokenTTL, "refresh-token-ttl", 30*24*time.Hour, "time to live of a session without refreshing")
+	flag.Func("jwt-keys", "kid=source token key, repeated for every key, the source is a PEM file of an RSA or Ed25519 key or hs256:secret (the -k secret if not set, its default is refused with -e production)",
+		func(spec string) error {
+			// the keys are kept one per line, a line break cannot be a part of the secret
+			if JWTKeys != "" {
+				JWTKeys += "\n"
+			}
+			JWTKeys += spec
+			return nil
+		})
+	flag.StringVar(&JWTActiveKID, "jwt-active-kid", "", "kid of the key signing new tokens (the first of -jwt-keys if empty)")
+	flag.BoolVar(&CookieSecure, "cookie-secure", true, "send the auth cookies over https only (false by default outside production)")
+	flag.StringVar(&CookieSameSite, "cookie-samesite", "lax", "SameSite attribute of the auth cookies (strict, lax or none)")
+	flag.StringVar(&CookieDomain, "cookie-domain", "", "Domain attribute of the auth cookies (the host only if empty)")
+	flag.BoolVar(&CSRFProtection, "csrf-protection", true, "require the X-CSRF-Token header on the state-changing requests authorized by the cookie (false by default outside production)")
+	flag.DurationVar(&PasswordResetTTL, "password-reset-ttl", time.Hour, "time to live of a password reset token")
+	flag.StringVar(&Notifier, "notifier", "log", "sender of the password reset tokens (log or file, both are for development)")
+	flag.StringVar(&NotifierFile, "notifier-file", "notifications.log", "file of the file notifier")
+	flag.IntVar(&LoginMaxFailures, "login-max-failures", 10, "failed logins of a login before the lockout (0 turns the login throttling off)")
+	flag.IntVar(&LoginIPMaxFailures, "login-ip-max-failures", 100, "failed logins from a client address before the lockout")
+	flag.DurationVar(&LoginDelay, "login-delay", time.Second, "first delay after the failed logins, it doubles with every failure")
+	flag.DurationVar(&LoginLockout, "login-lockout", 15*time.Minute, "lockout duration, the failures older than it are forgotten")
+	flag.StringVar(&PasswordHash, "password-hash", "argon2id", "algorithm of the new password hashes (argon2id or bcrypt), the others are upgraded on login")
+	flag.IntVar(&Argon2Time, "argon2-time", 2, "argon2id passes")
+	flag.IntVar(&Argon2Memory, "argon2-memory", 19*1024, "argon2id memory in KiB")
+	flag.IntVar(&Argon2Threads, "argon2-threads", 1, "argon2id parallelism")
+	flag.IntVar(&BcryptCost, "bcrypt-cost", 10, "bcrypt cost")
 	flag.Parse()
 
 	envflags.TryUseEnvString(&RunAddress, "RUN_ADDRESS")
@@ -39,4 +120,55 @@ func ParseFlags() {
 	envflags.TryUseEnvDuration(&WorkerPeriod, "WORKER_PERIOD")
 	envflags.TryUseEnvInt(&WorkerPackLimit, "WORKER_PACK_LIMIT")
 	envflags.TryUseEnvInt(&WorkerPoolSize, "WORKER_POOL_SIZE")
+	envflags.TryUseEnvDuration(&EventsRetention, "EVENTS_RETENTION")
+	envflags.TryUseEnvDuration(&WebhookPeriod, "WEBHOOK_PERIOD")
+	envflags.TryUseEnvInt(&WebhookMaxAttempts, "WEBHOOK_MAX_ATTEMPTS")
+	envflags.TryUseEnvBool(&WebhookAllowPrivate, "WEBHOOK_ALLOW_PRIVATE")
+	envflags.TryUseEnvDuration(&WebhookRetention, "WEBHOOK_RETENTION")
+	envflags.TryUseEnvInt(&BatchMaxSize, "BATCH_MAX_SIZE")
+	envflags.TryUseEnvInt(&MaxBodySize, "MAX_BODY_SIZE")
+	envflags.TryUseEnvBool(&OpenAPIValidation, "OPENAPI_VALIDATION")
+	envflags.TryUseEnvDuration(&CancelWindow, "CANCEL_WINDOW")
+	envflags.TryUseEnvDuration(&HoldTTL, "HOLD_TTL")
+	envflags.TryUseEnvDuration(&HoldMaxTTL, "HOLD_MAX_TTL")
+	envflags.TryUseEnvDuration(&HoldExpiryPeriod, "HOLD_EXPIRY_PERIOD")
+	envflags.TryUseEnvDuration(&IdempotencyRetention, "IDEMPOTENCY_RETENTION")
+	envflags.TryUseEnvString(&Currency, "CURRENCY")
+	envflags.TryUseEnvDuration(&AccessTokenTTL, "ACCESS_TOKEN_TTL")
+	envflags.TryUseEnvDuration(&RefreshTokenTTL, "REFRESH_TOKEN_TTL")
+	envflags.TryUseEnvString(&JWTKeys, "JWT_KEYS") // one kid=source per line
+	envflags.TryUseEnvString(&JWTActiveKID, "JWT_ACTIVE_KID")
+	// the cookie defaults depend on the environment type like the refused default -k secret
+	if !isFlagSet("cookie-secure") {
+		CookieSecure = EnvType == "production"
+	}
+	if !isFlagSet("csrf-protection") {
+		CSRFProtection = EnvType == "production"
+	}
+	envflags.TryUseEnvBool(&CookieSecure, "COOKIE_SECURE")
+	envflags.TryUseEnvString(&CookieSameSite, "COOKIE_SAMESITE")
+	envflags.TryUseEnvString(&CookieDomain, "COOKIE_DOMAIN")
+	envflags.TryUseEnvBool(&CSRFProtection, "CSRF_PROTECTION")
+	envflags.TryUseEnvDuration(&PasswordResetTTL, "PASSWORD_RESET_TTL")
+	envflags.TryUseEnvString(&Notifier, "NOTIFIER")
+	envflags.TryUseEnvString(&NotifierFile, "NOTIFIER_FILE")
+	envflags.TryUseEnvInt(&LoginMaxFailures, "LOGIN_MAX_FAILURES")
+	envflags.TryUseEnvInt(&LoginIPMaxFailures, "LOGIN_IP_MAX_FAILURES")
+	envflags.TryUseEnvDuration(&LoginDelay, "LOGIN_DELAY")
+	envflags.TryUseEnvDuration(&LoginLockout, "LOGIN_LOCKOUT")
+	envflags.TryUseEnvString(&PasswordHash, "PASSWORD_HASH")
+	envflags.TryUseEnvInt(&Argon2Time, "ARGON2_TIME")
+	envflags.TryUseEnvInt(&Argon2Memory, "ARGON2_MEMORY")
+	envflags.TryUseEnvInt(&Argon2Threads, "ARGON2_THREADS")
+	envflags.TryUseEnvInt(&BcryptCost, "BCRYPT_COST")
+}
+
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
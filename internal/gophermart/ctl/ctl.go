@@ -0,0 +1,97 @@
+package ctl
+
+import (
+	"flag"
+	"fmt"
+	"github.com/zasuchilas/gophermart/internal/gophermart/config"
+	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage/pgstorage"
+	"github.com/zasuchilas/gophermart/pkg/envflags"
+	"io"
+	"os"
+	"sort"
+)
+
+const (
+	ExitOK    = 0
+	ExitError = 1
+	ExitDrift = 2 // inconsistencies were found and left as is
+)
+
+type command struct {
+	usage string
+	run   func(c *Ctl, args []string) int
+}
+
+var commands = map[string]command{
+	"grant-role": {
+		usage: "set the role of a user (user, support or admin)",
+		run:   (*Ctl).grantRole,
+	},
+	"reconcile": {
+		usage: "recompute user balances from orders and withdrawals, report and repair the drift",
+		run:   (*Ctl).reconcile,
+	},
+	"reverse-withdrawal": {
+		usage: "reverse a withdrawal and return its sum to the user balance",
+		run:   (*Ctl).reverseWithdrawal,
+	},
+	"unlock": {
+		usage: "remove the failed login counters and the lockout of a login or a client address",
+		run:   (*Ctl).unlock,
+	},
+}
+
+// Ctl is the operator command line tool working directly with the gophermart database.
+type Ctl struct {
+	stdout io.Writer
+	stderr io.Writer
+	store  storage.Storage
+}
+
+func New() *Ctl {
+	return &Ctl{
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	}
+}
+
+func (c *Ctl) Run(args []string) int {
+	fs := flag.NewFlagSet("gophermartctl", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.StringVar(&config.DatabaseURI, "d", "", "database connection string")
+	fs.StringVar(&config.LogLevel, "l", "error", "logging level")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(c.stderr, "Usage: gophermartctl [flags] <command> [command flags]")
+		_, _ = fmt.Fprintln(c.stderr, "\nFlags:")
+		fs.PrintDefaults()
+		_, _ = fmt.Fprintln(c.stderr, "\nCommands:")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			_, _ = fmt.Fprintf(c.stderr, "  %-20s %s\n", name, commands[name].usage)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return ExitError
+	}
+
+	envflags.TryUseEnvString(&config.DatabaseURI, "DATABASE_URI")
+	envflags.TryUseEnvString(&config.LogLevel, "LOG_LEVEL")
+
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fs.Usage()
+		return ExitError
+	}
+
+	logger.Init()
+	c.store = pgstorage.New()
+	defer c.store.Stop()
+
+	return cmd.run(c, fs.Args()[1:])
+}
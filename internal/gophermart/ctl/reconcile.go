@@ -0,0 +1,70 @@
+package ctl
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"go.uber.org/zap"
+	"time"
+)
+
+// reconcile prints the JSON report and exits with ExitDrift
+// if the drift was found and was not repaired (dry-run mode).
+func (c *Ctl) reconcile(args []string) int {
+	var dryRun bool
+	fs := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.BoolVar(&dryRun, "dry-run", true, "only report the drift without repairing it")
+	if err := fs.Parse(args); err != nil {
+		return ExitError
+	}
+
+	ctx := context.Background()
+	report := &models.ReconcileReport{
+		DryRun:    dryRun,
+		StartedAt: time.Now().Format(time.RFC3339),
+	}
+
+	checked, drifts, err := c.store.FindBalanceDrifts(ctx)
+	if err != nil {
+		logger.Log.Error("finding balance drifts", zap.String("error", err.Error()))
+		return ExitError
+	}
+	report.CheckedUsers = checked
+	report.Drifts = drifts
+
+	if !dryRun {
+		// the counters are checked again under the lock, they could have been changed meanwhile
+		repaired := make([]*models.BalanceDrift, 0, len(drifts))
+		for _, drift := range drifts {
+			v, er := c.store.RepairBalanceDrift(ctx, drift.UserID)
+			if er != nil {
+				logger.Log.Error("repairing balance drift",
+					zap.Int64("user_id", drift.UserID), zap.String("error", er.Error()))
+				repaired = append(repaired, drift)
+				continue
+			}
+			if v == nil {
+				continue
+			}
+			repaired = append(repaired, v)
+			report.RepairedUsers++
+		}
+		report.Drifts = repaired
+	}
+	report.DriftedUsers = len(report.Drifts)
+
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(report); err != nil {
+		logger.Log.Error("error encoding report", zap.String("error", err.Error()))
+		return ExitError
+	}
+
+	if report.RepairedUsers < report.DriftedUsers {
+		return ExitDrift
+	}
+	return ExitOK
+}
@@ -0,0 +1,79 @@
+package ctl
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"go.uber.org/zap"
+	"slices"
+	"strings"
+)
+
+// unlock removes the failed login counters and the lockout of a login or a client address.
+func (c *Ctl) unlock(args []string) int {
+	var login, ip string
+	fs := flag.NewFlagSet("unlock", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.StringVar(&login, "login", "", "user login")
+	fs.StringVar(&ip, "ip", "", "client address")
+	if err := fs.Parse(args); err != nil {
+		return ExitError
+	}
+	if login == "" && ip == "" {
+		_, _ = fmt.Fprintln(c.stderr, "the -login or -ip flag is required")
+		fs.Usage()
+		return ExitError
+	}
+
+	keys := make([]string, 0, 2)
+	if login != "" {
+		keys = append(keys, storage.LoginKey(login))
+	}
+	if ip != "" {
+		keys = append(keys, storage.IPKey(ip))
+	}
+
+	n, err := c.store.ResetLoginFailures(context.Background(), keys...)
+	if err != nil {
+		logger.Log.Error("resetting login failures", zap.Strings("keys", keys), zap.String("error", err.Error()))
+		return ExitError
+	}
+
+	_, _ = fmt.Fprintf(c.stdout, "%d counters removed\n", n)
+	return ExitOK
+}
+
+// grantRole sets the role of the user, the tokens with the previous role stop working
+// and the user gets the new one on the next token refresh.
+func (c *Ctl) grantRole(args []string) int {
+	var login, role string
+	fs := flag.NewFlagSet("grant-role", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.StringVar(&login, "login", "", "user login")
+	fs.StringVar(&role, "role", "", "role ("+strings.Join(models.Roles, ", ")+")")
+	if err := fs.Parse(args); err != nil {
+		return ExitError
+	}
+	if login == "" || !slices.Contains(models.Roles, role) {
+		_, _ = fmt.Fprintln(c.stderr, "the -login and -role flags are required")
+		fs.Usage()
+		return ExitError
+	}
+
+	userID, err := c.store.SetUserRole(context.Background(), login, role)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			_, _ = fmt.Fprintf(c.stderr, "user %s not found\n", login)
+			return ExitError
+		}
+		logger.Log.Error("granting role", zap.String("login", login), zap.String("error", err.Error()))
+		return ExitError
+	}
+
+	_, _ = fmt.Fprintf(c.stdout, "user %s (id %d) is %s now\n", login, userID, role)
+	return ExitOK
+}
@@ -0,0 +1,54 @@
+package ctl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"go.uber.org/zap"
+)
+
+// reverseWithdrawal reverses the withdrawal after the user cancel window is closed
+// and prints the reversed withdrawal as JSON.
+func (c *Ctl) reverseWithdrawal(args []string) int {
+	var (
+		id     int64
+		reason string
+	)
+	fs := flag.NewFlagSet("reverse-withdrawal", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Int64Var(&id, "id", 0, "withdrawal id")
+	fs.StringVar(&reason, "reason", "", "reason of the reversal (required)")
+	if err := fs.Parse(args); err != nil {
+		return ExitError
+	}
+	if id <= 0 || reason == "" {
+		_, _ = fmt.Fprintln(c.stderr, "the -id and -reason flags are required")
+		fs.Usage()
+		return ExitError
+	}
+
+	withdrawal, err := c.store.ReverseWithdrawal(context.Background(), id, reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			_, _ = fmt.Fprintf(c.stderr, "withdrawal %d not found\n", id)
+		case errors.Is(err, storage.ErrReversed):
+			_, _ = fmt.Fprintf(c.stderr, "withdrawal %d is already reversed\n", id)
+		default:
+			logger.Log.Error("reversing withdrawal", zap.Int64("id", id), zap.String("error", err.Error()))
+		}
+		return ExitError
+	}
+
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(withdrawal); err != nil {
+		logger.Log.Error("error encoding withdrawal", zap.String("error", err.Error()))
+		return ExitError
+	}
+	return ExitOK
+}
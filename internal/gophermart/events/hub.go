@@ -0,0 +1,176 @@
+package events
+
+import (
+	"context"
+	"github.com/zasuchilas/gophermart/internal/gophermart/config"
+	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const (
+	subscriberBuffer = 64
+	reconnectPause   = 3 * time.Second
+	prunePeriod      = time.Hour
+)
+
+// Hub listens to the events committed by any gophermart process
+// and fans them out to the subscribers of the current process.
+type Hub struct {
+	waitGroup *sync.WaitGroup
+	store     storage.Storage
+	ctx       context.Context
+	cancel    context.CancelFunc
+
+	mu          sync.Mutex
+	subscribers map[int64]map[chan *models.Event]struct{}
+	lastID      int64
+}
+
+func New(store storage.Storage, wg *sync.WaitGroup) *Hub {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Hub{
+		waitGroup:   wg,
+		store:       store,
+		ctx:         ctx,
+		cancel:      cancel,
+		subscribers: make(map[int64]map[chan *models.Event]struct{}),
+	}
+}
+
+func (h *Hub) Start() {
+	go h.prune()
+
+	connected := false
+	for {
+		if connected {
+			// the notifications could have been lost while the connection was broken
+			h.catchUp()
+		}
+		connected = true
+
+		err := h.store.ListenEvents(h.ctx, h.publish)
+		if h.ctx.Err() != nil {
+			break
+		}
+		logger.Log.Info("listening to events failed", zap.String("error", err.Error()))
+
+		select {
+		case <-h.ctx.Done():
+		case <-time.After(reconnectPause):
+		}
+	}
+}
+
+func (h *Hub) Stop() {
+	h.cancel()
+
+	h.mu.Lock()
+	for userID, subs := range h.subscribers {
+		for ch := range subs {
+			close(ch)
+		}
+		delete(h.subscribers, userID)
+	}
+	h.mu.Unlock()
+
+	h.waitGroup.Done()
+}
+
+// Subscribe returns the channel of the user events and the function to unsubscribe.
+// The channel is closed if the subscriber falls behind,
+// the client is expected to reconnect and to replay the missed events.
+func (h *Hub) Subscribe(userID int64) (<-chan *models.Event, func()) {
+	ch := make(chan *models.Event, subscriberBuffer)
+
+	h.mu.Lock()
+	subs, ok := h.subscribers[userID]
+	if !ok {
+		subs = make(map[chan *models.Event]struct{})
+		h.subscribers[userID] = subs
+	}
+	subs[ch] = struct{}{}
+	h.mu.Unlock()
+
+	unsubscribe := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.remove(userID, ch)
+	}
+	return ch, unsubscribe
+}
+
+// remove must be called with the lock held
+func (h *Hub) remove(userID int64, ch chan *models.Event) {
+	subs, ok := h.subscribers[userID]
+	if !ok {
+		return
+	}
+	if _, ok = subs[ch]; !ok {
+		return
+	}
+	delete(subs, ch)
+	close(ch)
+	if len(subs) == 0 {
+		delete(h.subscribers, userID)
+	}
+}
+
+func (h *Hub) publish(e *models.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if e.ID > h.lastID {
+		h.lastID = e.ID
+	}
+
+	for ch := range h.subscribers[e.UserID] {
+		select {
+		case ch <- e:
+		default:
+			logger.Log.Info("events subscriber falls behind", zap.Int64("user_id", e.UserID))
+			h.remove(e.UserID, ch)
+		}
+	}
+}
+
+func (h *Hub) catchUp() {
+	h.mu.Lock()
+	lastID := h.lastID
+	h.mu.Unlock()
+	if lastID == 0 {
+		// nothing was received yet, the clients replay the history with Last-Event-ID
+		return
+	}
+
+	events, err := h.store.GetEvents(h.ctx, lastID)
+	if err != nil {
+		logger.Log.Info("error getting missed events from db", zap.String("error", err.Error()))
+		return
+	}
+	for _, e := range events {
+		h.publish(e)
+	}
+}
+
+func (h *Hub) prune() {
+	ticker := time.NewTicker(prunePeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := h.store.DeleteEventsBefore(h.ctx, time.Now().Add(-config.EventsRetention))
+			if err != nil {
+				logger.Log.Info("error deleting old events", zap.String("error", err.Error()))
+				continue
+			}
+			logger.Log.Debug("old events deleted", zap.Int64("count", deleted))
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var ErrBadCursor = errors.New("bad cursor")
+
+// ListQuery describes a page of a user list (orders, withdrawals).
+// The zero value means the whole list in the default order (newest first).
+type ListQuery struct {
+	Limit    int // 0 means without a limit
+	Cursor   *Cursor
+	Statuses []string
+	From     time.Time // inclusive, zero means without a bound
+	To       time.Time // exclusive, zero means without a bound
+	Asc      bool
+}
+
+// Cursor points to the last row of the previous page.
+// Clients get it as an opaque string.
+type Cursor struct {
+	Time time.Time `json:"t"`
+	ID   int64     `json:"id"`
+}
+
+func (c *Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func ParseCursor(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	var c Cursor
+	if err = json.Unmarshal(b, &c); err != nil || c.ID <= 0 {
+		return nil, ErrBadCursor
+	}
+	return &c, nil
+}
@@ -1,8 +1,78 @@
 package models
 
+import (
+	"encoding/json"
+	"github.com/zasuchilas/gophermart/pkg/amount"
+	"time"
+)
+
+const (
+	WebhookEventOrderProcessed = "order.processed"
+	WebhookEventOrderInvalid   = "order.invalid"
+
+	WebhookDeliveryPending   = "PENDING"
+	WebhookDeliveryDelivered = "DELIVERED"
+	WebhookDeliveryFailed    = "FAILED"
+)
+
+const (
+	OrderUploadAccepted        = "accepted"
+	OrderUploadAlreadyUploaded = "already_uploaded"         // by the current user
+	OrderUploadConflict        = "uploaded_by_another_user" // the number belongs to another user
+	OrderUploadInvalid         = "invalid"                  // not a number or the luhn check failed
+)
+
+const (
+	HoldStatusActive   = "ACTIVE"
+	HoldStatusCaptured = "CAPTURED" // turned into a withdrawal
+	HoldStatusReleased = "RELEASED" // freed by the user
+	HoldStatusExpired  = "EXPIRED"  // freed automatically after expires_at
+)
+
+const (
+	WithdrawalStatusDone     = "DONE"
+	WithdrawalStatusReversed = "REVERSED"
+
+	WithdrawalReversedByUser  = "user"  // canceled by the user within the cancel window
+	WithdrawalReversedByAdmin = "admin" // reversed by the operator
+)
+
+const (
+	EventTypeOrder   = "order"   // the order status or accrual has changed, data is Order
+	EventTypeBalance = "balance" // the balance has changed, data is UserBalance
+)
+
+// The roles are ordered, a role has the rights of the previous ones
+const (
+	RoleUser    = "user"
+	RoleSupport = "support" // views the users in the admin API
+	RoleAdmin   = "admin"   // changes the users in the admin API
+)
+
+var Roles = []string{RoleUser, RoleSupport, RoleAdmin}
+
+// The scopes of the API keys, the keys are refused on the routes out of their scopes
+const (
+	ScopeOrdersRead   = "orders:read"
+	ScopeOrdersWrite  = "orders:write"
+	ScopeBalanceRead  = "balance:read"  // balance, withdrawals, holds, ledger and events
+	ScopeBalanceWrite = "balance:write" // withdrawing and holding
+)
+
+var Scopes = []string{ScopeOrdersRead, ScopeOrdersWrite, ScopeBalanceRead, ScopeBalanceWrite}
+
+const (
+	AuditActionView          = "view" // the details tell what was viewed
+	AuditActionAdjustBalance = "balance.adjust"
+	AuditActionRepollOrder   = "order.repoll"
+	AuditActionDeleteUser    = "user.delete"
+	AuditActionRestoreUser   = "user.restore"
+)
+
 type RegisterRequest struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
+	Currency string `json:"currency,omitempty"` // the account currency, the default one if omitted
 }
 
 type LoginRequest struct {
@@ -10,48 +80,291 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // seconds
+	RefreshToken string `json:"refresh_token"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type PasswordResetRequest struct {
+	Login string `json:"login"`
+}
+
+type PasswordResetConfirmRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+type DeleteUserRequest struct {
+	Password string `json:"password"`
+}
+
+// AccountClosure is the balance forfeited on the account deletion (with the released holds)
+type AccountClosure struct {
+	Forfeited amount.Amount `json:"forfeited"`
+	Currency  string        `json:"currency"`
+}
+
+// AdminUser is the user as the operators see it
+type AdminUser struct {
+	ID        int64         `json:"id"`
+	Login     string        `json:"login"`
+	Role      string        `json:"role"`
+	Currency  string        `json:"currency"`
+	Balance   amount.Amount `json:"balance"`
+	Held      amount.Amount `json:"held"`
+	Withdrawn amount.Amount `json:"withdrawn"`
+	Deleted   bool          `json:"deleted"`
+	CreatedAt string        `json:"created_at"`
+	DeletedAt string        `json:"deleted_at,omitempty"`
+}
+
+type AdjustmentRequest struct {
+	Amount   amount.Amount `json:"amount"`             // negative to debit the balance
+	Currency string        `json:"currency,omitempty"` // the account currency if omitted
+	Reason   string        `json:"reason"`
+}
+
+// AdminActionRequest is the body of the admin actions requiring a reason
+type AdminActionRequest struct {
+	Reason string `json:"reason"`
+}
+
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	UserID    int64           `json:"user_id,omitempty"`
+	Reason    string          `json:"reason,omitempty"`
+	Details   json.RawMessage `json:"details,omitempty"`
+	CreatedAt string          `json:"created_at"`
+}
+
+type Session struct {
+	ID     int64
+	UserID int64
+	Role   string
+}
+
 type LoginData struct {
 	UserID       int64
 	Login        string
 	PasswordHash string
+	Role         string
+}
+
+// LoginAttempt is the counted login attempt of a failed login counter key
+type LoginAttempt struct {
+	Failures    int
+	LockedUntil time.Time // zero or past if the key is not locked
+	Rejected    bool      // the key was locked, the attempt is not counted
 }
 
 type Order struct {
-	OrderNum   string  `json:"number"`
-	Status     string  `json:"status"`
-	Accrual    float64 `json:"accrual"`
-	UploadedAt string  `json:"uploaded_at"`
+	OrderNum   string        `json:"number"`
+	Status     string        `json:"status"`
+	Accrual    amount.Amount `json:"accrual"`
+	Currency   string        `json:"currency"`
+	UploadedAt string        `json:"uploaded_at,omitempty"`
+}
+
+type OrderUploadResult struct {
+	OrderNum string `json:"number"`
+	Result   string `json:"result"`
+}
+
+// IdempotentResponse is the stored response replayed for a repeated Idempotency-Key
+type IdempotentResponse struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+type OrderDetails struct {
+	Order
+	History []*OrderStatusChange `json:"history"`
+}
+
+type OrderStatusChange struct {
+	Status    string        `json:"status"`
+	Accrual   amount.Amount `json:"accrual"`
+	ChangedAt string        `json:"changed_at"`
 }
 
+// UserBalance is the balance of the user, the held amount is reserved
+// and cannot be spent until the hold is released. Current is the available amount too.
 type UserBalance struct {
-	Current   float64 `json:"current"`
-	Withdrawn float64 `json:"withdrawn"`
+	Current   amount.Amount `json:"current"`   // the available and the held amounts
+	Available amount.Amount `json:"available"` // can be withdrawn or held
+	Held      amount.Amount `json:"held"`
+	Withdrawn amount.Amount `json:"withdrawn"`
+	Currency  string        `json:"currency"`
 }
 
 type WithdrawRequest struct {
-	Order string  `json:"order"`
-	Sum   float64 `json:"sum"`
+	Order    string        `json:"order"`
+	Sum      amount.Amount `json:"sum"`
+	Currency string        `json:"currency,omitempty"` // the account currency if omitted
+}
+
+type HoldRequest struct {
+	Order    string        `json:"order"`
+	Sum      amount.Amount `json:"sum"`
+	Currency string        `json:"currency,omitempty"` // the account currency if omitted
+	TTL      int           `json:"ttl,omitempty"`      // seconds, the default hold ttl if omitted
+}
+
+type Hold struct {
+	ID           int64         `json:"id"`
+	OrderNum     string        `json:"order"`
+	Sum          amount.Amount `json:"sum"`
+	Currency     string        `json:"currency"`
+	Status       string        `json:"status"`
+	ExpiresAt    string        `json:"expires_at"`
+	CreatedAt    string        `json:"created_at"`
+	FinishedAt   string        `json:"finished_at,omitempty"`
+	WithdrawalID int64         `json:"withdrawal_id,omitempty"` // the withdrawal made by capturing
 }
 
 type WithdrawalsData []*Withdrawal
 
 type Withdrawal struct {
-	OrderNum    string  `json:"order"`
-	Sum         float64 `json:"sum"`
-	ProcessedAt string  `json:"processed_at"`
+	ID             int64         `json:"id"`
+	OrderNum       string        `json:"order"`
+	Sum            amount.Amount `json:"sum"`
+	Currency       string        `json:"currency"`
+	ProcessedAt    string        `json:"processed_at"`
+	Status         string        `json:"status"`
+	ReversedAt     string        `json:"reversed_at,omitempty"`
+	ReversedBy     string        `json:"reversed_by,omitempty"`
+	ReversalReason string        `json:"reversal_reason,omitempty"`
+}
+
+type LedgerEntry struct {
+	ID        int64         `json:"id"`
+	Operation string        `json:"operation"`
+	Debit     string        `json:"debit"`
+	Credit    string        `json:"credit"`
+	OrderNum  string        `json:"order,omitempty"`
+	Amount    amount.Amount `json:"amount"`  // signed change of the user balance
+	Balance   amount.Amount `json:"balance"` // user balance after the entry
+	Currency  string        `json:"currency"`
+	CreatedAt string        `json:"created_at"`
+}
+
+type Event struct {
+	ID        int64           `json:"id"`
+	UserID    int64           `json:"-"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt string          `json:"created_at"`
 }
 
 type OrderRow struct {
 	ID         int64
 	OrderNum   string
 	Status     string
-	Accrual    float64
+	Accrual    amount.Amount
+	Currency   string // the account currency
 	UserID     int64
 	UploadedAt string
 }
 
 type OrderStateResponse struct {
-	OrderNum string  `json:"number"`
-	Status   string  `json:"status"`
-	Accrual  float64 `json:"accrual"`
+	OrderNum string        `json:"number"`
+	Status   string        `json:"status"`
+	Accrual  amount.Amount `json:"accrual"`
+	Currency string        `json:"currency,omitempty"` // the default currency if omitted
+}
+
+type BalanceDrift struct {
+	UserID            int64         `json:"user_id"`
+	Login             string        `json:"login"`
+	Currency          string        `json:"currency"`
+	StoredBalance     amount.Amount `json:"stored_balance"`
+	ExpectedBalance   amount.Amount `json:"expected_balance"`
+	StoredWithdrawn   amount.Amount `json:"stored_withdrawn"`
+	ExpectedWithdrawn amount.Amount `json:"expected_withdrawn"`
+	StoredHeld        amount.Amount `json:"stored_held"`
+	ExpectedHeld      amount.Amount `json:"expected_held"`
+	Repaired          bool          `json:"repaired"`
+}
+
+type ReconcileReport struct {
+	DryRun        bool            `json:"dry_run"`
+	StartedAt     string          `json:"started_at"`
+	CheckedUsers  int             `json:"checked_users"`
+	DriftedUsers  int             `json:"drifted_users"`
+	RepairedUsers int             `json:"repaired_users"`
+	Drifts        []*BalanceDrift `json:"drifts"`
+}
+
+type WebhookRequest struct {
+	URL string `json:"url"`
+}
+
+type Webhook struct {
+	ID        int64  `json:"id"`
+	URL       string `json:"url"`
+	Secret    string `json:"secret,omitempty"` // returned only once on creating
+	CreatedAt string `json:"created_at"`
+}
+
+type APIKey struct {
+	ID         int64    `json:"id"`
+	UserID     int64    `json:"-"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`        // the beginning of the key to tell the keys apart
+	Key        string   `json:"key,omitempty"` // returned only once on creating
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"created_at"`
+	ExpiresAt  string   `json:"expires_at,omitempty"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+}
+
+type APIKeyRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn string   `json:"expires_in,omitempty"` // Go duration like "720h", no expiration by default
+}
+
+type WebhookAttempt struct {
+	StatusCode  int    `json:"status_code"`
+	Error       string `json:"error,omitempty"`
+	DurationMS  int64  `json:"duration_ms"`
+	AttemptedAt string `json:"attempted_at"`
+}
+
+type WebhookPayload struct {
+	Event      string `json:"event"`
+	Order      *Order `json:"order"`
+	OccurredAt string `json:"occurred_at"`
+}
+
+type WebhookDelivery struct {
+	ID             int64             `json:"id"`
+	WebhookID      int64             `json:"webhook_id"`
+	Event          string            `json:"event"`
+	Payload        json.RawMessage   `json:"payload"`
+	Status         string            `json:"status"`
+	Attempts       int               `json:"attempts"`
+	LastStatusCode int               `json:"last_status_code"`
+	LastError      string            `json:"last_error,omitempty"`
+	NextAttemptAt  string            `json:"next_attempt_at,omitempty"`
+	CreatedAt      string            `json:"created_at"`
+	DeliveredAt    string            `json:"delivered_at,omitempty"`
+	AttemptLog     []*WebhookAttempt `json:"attempt_log"` // the latest attempts first
+
+	URL    string `json:"-"`
+	Secret string `json:"-"`
 }
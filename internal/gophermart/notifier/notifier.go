@@ -0,0 +1,82 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
+	"go.uber.org/zap"
+	"os"
+	"sync"
+	"time"
+)
+
+// The notifier delivers the messages to the users, the password reset tokens for now.
+// Only the development senders are built in, a mail or sms gateway is another Notifier.
+
+const (
+	TypeLog  = "log"
+	TypeFile = "file"
+)
+
+type Notifier interface {
+	SendPasswordReset(ctx context.Context, login, token string, expiresAt time.Time) error
+}
+
+// New returns the notifier of the type, path is the file of the file notifier
+func New(kind, path string) (Notifier, error) {
+	switch kind {
+	case TypeLog:
+		return &LogNotifier{}, nil
+	case TypeFile:
+		if path == "" {
+			return nil, fmt.Errorf("the file of the %s notifier is not set", TypeFile)
+		}
+		return &FileNotifier{path: path}, nil
+	}
+	return nil, fmt.Errorf("unknown notifier %q", kind)
+}
+
+// LogNotifier writes the messages into the service log, it is for development only
+type LogNotifier struct{}
+
+func (n *LogNotifier) SendPasswordReset(_ context.Context, login, token string, expiresAt time.Time) error {
+	logger.Log.Info("password reset",
+		zap.String("login", login),
+		zap.String("token", token),
+		zap.Time("expires_at", expiresAt))
+	return nil
+}
+
+// FileNotifier appends the messages to the file as JSON lines
+type FileNotifier struct {
+	path string
+	mu   sync.Mutex
+}
+
+type message struct {
+	Type      string    `json:"type"`
+	Login     string    `json:"login"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (n *FileNotifier) SendPasswordReset(_ context.Context, login, token string, expiresAt time.Time) error {
+	b, err := json.Marshal(message{Type: "password_reset", Login: login, Token: token, ExpiresAt: expiresAt})
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(b, '\n'))
+	if er := f.Close(); err == nil {
+		err = er
+	}
+	return err
+}
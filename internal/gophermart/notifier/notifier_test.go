@@ -0,0 +1,57 @@
+package notifier
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.log")
+	n, err := New(TypeFile, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	for _, token := range []string{"first", "second"} {
+		if err = n.SendPasswordReset(context.Background(), "user", token, expiresAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tokens := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var m message
+		if err = json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Login != "user" || !m.ExpiresAt.Equal(expiresAt) {
+			t.Errorf("unexpected message %+v", m)
+		}
+		tokens = append(tokens, m.Token)
+	}
+	if len(tokens) != 2 || tokens[0] != "first" || tokens[1] != "second" {
+		t.Errorf("expected the tokens in order, got %v", tokens)
+	}
+}
+
+func TestNewUnknown(t *testing.T) {
+	if _, err := New("smtp", ""); err == nil {
+		t.Error("expected an error for an unknown notifier")
+	}
+	if _, err := New(TypeFile, ""); err == nil {
+		t.Error("expected an error for the file notifier without a file")
+	}
+}
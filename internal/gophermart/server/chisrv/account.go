@@ -0,0 +1,205 @@
+package chisrv
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/zasuchilas/gophermart/internal/gophermart/config"
+	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"github.com/zasuchilas/gophermart/pkg/httperr"
+	"github.com/zasuchilas/gophermart/pkg/passhash"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// changePassword requires the current password, the other sessions of the user are revoked
+func (s *ChiServer) changePassword(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := getUserID(r)
+	if err != nil {
+		httperr.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+	sessionID, err := getSessionID(r)
+	if err != nil {
+		httperr.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	// decoding request
+	var req models.ChangePasswordRequest
+	if err = httperr.Decode(r, &req); err != nil {
+		httperr.WriteError(w, r, err)
+		return
+	}
+
+	// validation
+	if len(req.NewPassword) < 6 {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, "password is shorter than 6")
+		return
+	}
+	if !s.checkPassword(w, r, userID, req.CurrentPassword) {
+		return
+	}
+
+	// make password hash
+	pass, err := passhash.HashPassword(req.NewPassword)
+	if err != nil {
+		logger.Log.Error("failed to create a password hash", zap.String("error", err.Error()))
+		httperr.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	// write into db
+	err = s.store.ChangePassword(r.Context(), userID, pass, sessionID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			httperr.Status(w, r, http.StatusUnauthorized)
+			return
+		}
+		writeStorageError(w, r, "changing password", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// requestPasswordReset sends a single-use reset token to the user,
+// it responds the same way to the unknown logins so they cannot be probed
+func (s *ChiServer) requestPasswordReset(w http.ResponseWriter, r *http.Request) {
+
+	// decoding request
+	var req models.PasswordResetRequest
+	if err := httperr.Decode(r, &req); err != nil {
+		httperr.WriteError(w, r, err)
+		return
+	}
+	if req.Login == "" {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, "login is empty")
+		return
+	}
+
+	token, tokenHash, err := newRefreshToken()
+	if err != nil {
+		httperr.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	expiresAt := time.Now().Add(config.PasswordResetTTL)
+
+	// write into db
+	err = s.store.CreatePasswordReset(r.Context(), req.Login, tokenHash, expiresAt)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		// nothing to send
+	case err != nil:
+		writeStorageError(w, r, "creating password reset", err)
+		return
+	default:
+		if err = s.notifier.SendPasswordReset(r.Context(), req.Login, token, expiresAt); err != nil {
+			logger.Log.Error("sending password reset", zap.String("error", err.Error()))
+			httperr.Status(w, r, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// confirmPasswordReset sets the new password by the reset token and revokes all the sessions of the user
+func (s *ChiServer) confirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+
+	// decoding request
+	var req models.PasswordResetConfirmRequest
+	if err := httperr.Decode(r, &req); err != nil {
+		httperr.WriteError(w, r, err)
+		return
+	}
+
+	// validation
+	if req.Token == "" {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, "token is empty")
+		return
+	}
+	if len(req.NewPassword) < 6 {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, "password is shorter than 6")
+		return
+	}
+
+	// make password hash
+	pass, err := passhash.HashPassword(req.NewPassword)
+	if err != nil {
+		logger.Log.Error("failed to create a password hash", zap.String("error", err.Error()))
+		httperr.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	// write into db
+	err = s.store.ResetPassword(r.Context(), hashToken(req.Token), pass)
+	if err != nil {
+		writeStorageError(w, r, "resetting password", err)
+		return
+	}
+
+	clearAuthCookies(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+// deleteUser requires the password, the remaining balance is forfeited
+func (s *ChiServer) deleteUser(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := getUserID(r)
+	if err != nil {
+		httperr.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	// decoding request
+	var req models.DeleteUserRequest
+	if err = httperr.Decode(r, &req); err != nil {
+		httperr.WriteError(w, r, err)
+		return
+	}
+	if !s.checkPassword(w, r, userID, req.Password) {
+		return
+	}
+
+	// write into db
+	audit := &models.AuditEntry{Actor: "user", Action: models.AuditActionDeleteUser, Reason: "deleted by the user"}
+	closure, err := s.store.DeleteUser(r.Context(), userID, audit)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrGone) {
+			httperr.Status(w, r, http.StatusUnauthorized)
+			return
+		}
+		writeStorageError(w, r, "deleting user", err)
+		return
+	}
+
+	clearAuthCookies(w)
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err = enc.Encode(closure); err != nil {
+		logger.Log.Info("error encoding response", zap.String("error", err.Error()))
+		return
+	}
+}
+
+// checkPassword responds with 403 if the password of the user does not match
+func (s *ChiServer) checkPassword(w http.ResponseWriter, r *http.Request, userID int64, password string) bool {
+	passHash, err := s.store.GetPasswordHash(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			httperr.Status(w, r, http.StatusUnauthorized)
+			return false
+		}
+		writeStorageError(w, r, "getting password hash", err)
+		return false
+	}
+	if !passhash.CheckPasswordHash(password, passHash) {
+		httperr.Write(w, r, http.StatusForbidden, codeWrongPassword, "wrong password")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,342 @@
+package chisrv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Rhymond/go-money"
+	"github.com/go-chi/chi/v5"
+	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"github.com/zasuchilas/gophermart/pkg/httperr"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The admin API is for the operators, the support role views the users and the admin role changes them.
+// Every request is recorded in the audit log: the changes in their transactions, the views after reading.
+
+// newAuditEntry records the action of the operator making the request
+func newAuditEntry(r *http.Request, action, reason string) *models.AuditEntry {
+	userID, _ := getUserID(r)
+	actor := fmt.Sprintf("%s:%d", getRole(r), userID)
+	return &models.AuditEntry{Actor: actor, Action: action, Reason: reason}
+}
+
+// auditView records the view of the resource, the response is not held back by the failure
+func (s *ChiServer) auditView(r *http.Request, userID int64, resource string) {
+	audit := newAuditEntry(r, models.AuditActionView, "")
+	audit.UserID = userID
+	audit.Details, _ = json.Marshal(map[string]string{"resource": resource, "query": r.URL.RawQuery})
+	if err := s.store.AddAuditEntry(r.Context(), audit); err != nil {
+		logger.Log.Error("writing audit log", zap.String("error", err.Error()))
+	}
+}
+
+// adminUserID returns the id of the existing user from the url
+func (s *ChiServer) adminUserID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	userID, err := urlParamID(r, "id")
+	if err != nil {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, err.Error())
+		return 0, false
+	}
+	if _, err = s.store.GetUser(r.Context(), userID); err != nil {
+		writeStorageError(w, r, "reading from db", err)
+		return 0, false
+	}
+	return userID, true
+}
+
+// decodeReason reads the mandatory reason of an admin action
+func decodeReason(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req models.AdminActionRequest
+	if err := httperr.Decode(r, &req); err != nil {
+		httperr.WriteError(w, r, err)
+		return "", false
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, "the reason is required")
+		return "", false
+	}
+	return req.Reason, true
+}
+
+func writeAdminJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(v); err != nil {
+		logger.Log.Info("error encoding response", zap.String("error", err.Error()))
+	}
+}
+
+// adminGetUsers lists the users, the search parameter filters them by a part of the login
+func (s *ChiServer) adminGetUsers(w http.ResponseWriter, r *http.Request) {
+
+	// pagination and filters
+	q, err := parseListQuery(r, nil)
+	if err != nil {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, err.Error())
+		return
+	}
+
+	// reading from db
+	users, next, err := s.store.GetUsers(r.Context(), r.URL.Query().Get("search"), q)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		writeStorageError(w, r, "reading from db", err)
+		return
+	}
+	s.auditView(r, 0, "users")
+	if err != nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	setNextCursor(w, next)
+	writeAdminJSON(w, users)
+}
+
+func (s *ChiServer) adminGetUser(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := urlParamID(r, "id")
+	if err != nil {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, err.Error())
+		return
+	}
+
+	// reading from db
+	user, err := s.store.GetUser(r.Context(), userID)
+	if err != nil {
+		writeStorageError(w, r, "reading from db", err)
+		return
+	}
+	s.auditView(r, userID, "user")
+
+	writeAdminJSON(w, user)
+}
+
+func (s *ChiServer) adminGetUserOrders(w http.ResponseWriter, r *http.Request) {
+
+	userID, ok := s.adminUserID(w, r)
+	if !ok {
+		return
+	}
+
+	// pagination and filters
+	q, err := parseListQuery(r, orderStatuses)
+	if err != nil {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, err.Error())
+		return
+	}
+
+	// reading from db
+	orders, next, err := s.store.GetUserOrders(r.Context(), userID, q)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		writeStorageError(w, r, "reading from db", err)
+		return
+	}
+	s.auditView(r, userID, "orders")
+	if err != nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	setNextCursor(w, next)
+	writeAdminJSON(w, orders)
+}
+
+func (s *ChiServer) adminGetUserWithdrawals(w http.ResponseWriter, r *http.Request) {
+
+	userID, ok := s.adminUserID(w, r)
+	if !ok {
+		return
+	}
+
+	// pagination and filters
+	q, err := parseListQuery(r, nil)
+	if err != nil {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, err.Error())
+		return
+	}
+
+	// reading from db
+	withdrawals, next, err := s.store.GetUserWithdrawals(r.Context(), userID, q)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		writeStorageError(w, r, "reading from db", err)
+		return
+	}
+	s.auditView(r, userID, "withdrawals")
+	if err != nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	setNextCursor(w, next)
+	writeAdminJSON(w, withdrawals)
+}
+
+func (s *ChiServer) adminGetUserBalance(w http.ResponseWriter, r *http.Request) {
+
+	userID, ok := s.adminUserID(w, r)
+	if !ok {
+		return
+	}
+
+	// reading from db
+	balance, err := s.store.GetUserBalance(r.Context(), userID)
+	if err != nil {
+		writeStorageError(w, r, "reading from db", err)
+		return
+	}
+	s.auditView(r, userID, "balance")
+
+	writeAdminJSON(w, balance)
+}
+
+// adminAdjustBalance credits the positive amount to the balance or debits the negative one
+func (s *ChiServer) adminAdjustBalance(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := urlParamID(r, "id")
+	if err != nil {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, err.Error())
+		return
+	}
+
+	// decoding request
+	var req models.AdjustmentRequest
+	if err = httperr.Decode(r, &req); err != nil {
+		httperr.WriteError(w, r, err)
+		return
+	}
+
+	// validation
+	if strings.TrimSpace(req.Reason) == "" {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, "the reason is required")
+		return
+	}
+	currency, err := s.sumCurrency(r, userID, req.Currency)
+	if err != nil {
+		if errors.Is(err, errUnknownCurrency) {
+			httperr.Write(w, r, http.StatusBadRequest, codeUnknownCurrency, err.Error())
+			return
+		}
+		writeStorageError(w, r, "reading from db", err)
+		return
+	}
+	sum := money.New(req.Amount.Minor(), currency)
+	if sum.IsZero() {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, "the amount must not be zero")
+		return
+	}
+
+	// write into db
+	balance, err := s.store.AdjustBalance(r.Context(), userID, sum, newAuditEntry(r, models.AuditActionAdjustBalance, req.Reason))
+	if err != nil {
+		writeStorageError(w, r, "writing into db", err)
+		return
+	}
+
+	writeAdminJSON(w, balance)
+}
+
+// adminRepollOrder makes the worker ask the accrual system about the order again
+func (s *ChiServer) adminRepollOrder(w http.ResponseWriter, r *http.Request) {
+
+	reason, ok := decodeReason(w, r)
+	if !ok {
+		return
+	}
+
+	// write into db
+	orderNum := chi.URLParam(r, "number")
+	order, err := s.store.RepollOrder(r.Context(), orderNum, newAuditEntry(r, models.AuditActionRepollOrder, reason))
+	if err != nil {
+		writeStorageError(w, r, "writing into db", err)
+		return
+	}
+
+	writeAdminJSON(w, order)
+}
+
+// adminDeleteUser deletes the user the same way as the user does, the balance is forfeited
+func (s *ChiServer) adminDeleteUser(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := urlParamID(r, "id")
+	if err != nil {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, err.Error())
+		return
+	}
+	reason, ok := decodeReason(w, r)
+	if !ok {
+		return
+	}
+
+	// write into db
+	closure, err := s.store.DeleteUser(r.Context(), userID, newAuditEntry(r, models.AuditActionDeleteUser, reason))
+	if err != nil {
+		writeStorageError(w, r, "writing into db", err)
+		return
+	}
+
+	writeAdminJSON(w, closure)
+}
+
+// adminRestoreUser returns the deleted user with the forfeited balance
+func (s *ChiServer) adminRestoreUser(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := urlParamID(r, "id")
+	if err != nil {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, err.Error())
+		return
+	}
+	reason, ok := decodeReason(w, r)
+	if !ok {
+		return
+	}
+
+	// write into db
+	user, err := s.store.RestoreUser(r.Context(), userID, newAuditEntry(r, models.AuditActionRestoreUser, reason))
+	if err != nil {
+		writeStorageError(w, r, "writing into db", err)
+		return
+	}
+
+	writeAdminJSON(w, user)
+}
+
+// adminGetAuditLog lists the audit log, the user_id parameter filters it by the user
+func (s *ChiServer) adminGetAuditLog(w http.ResponseWriter, r *http.Request) {
+
+	var userID int64
+	if v := r.URL.Query().Get("user_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, "the user_id must be a positive number")
+			return
+		}
+		userID = id
+	}
+
+	// pagination and filters
+	q, err := parseListQuery(r, nil)
+	if err != nil {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, err.Error())
+		return
+	}
+
+	// reading from db
+	entries, next, err := s.store.GetAuditLog(r.Context(), userID, q)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeStorageError(w, r, "reading from db", err)
+		return
+	}
+
+	setNextCursor(w, next)
+	writeAdminJSON(w, entries)
+}
@@ -0,0 +1,221 @@
+package chisrv
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"github.com/zasuchilas/gophermart/pkg/httperr"
+	"go.uber.org/zap"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// An API key authorizes a machine client of the user instead of the access token.
+// It is sent in the X-API-Key header, works only within its scopes and never
+// manages the account, its keys and webhooks.
+
+const (
+	apiKeyHeader    = "X-API-Key"
+	apiKeyPrefix    = "gm_"
+	apiKeyPrefixLen = len(apiKeyPrefix) + 8 // shown in the key list
+	apiKeyMaxName   = 100
+)
+
+type apiKeyContextKey struct{}
+
+func newAPIKey() (key, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key = apiKeyPrefix + hex.EncodeToString(b)
+	return key, hashToken(key), nil
+}
+
+// apiKeyFromContext returns the key authorized the request, nil for the access tokens
+func apiKeyFromContext(ctx context.Context) *models.APIKey {
+	key, _ := ctx.Value(apiKeyContextKey{}).(*models.APIKey)
+	return key
+}
+
+// authenticate accepts the API key of the X-API-Key header or else the access token of the active session
+func (s *ChiServer) authenticate(next http.Handler) http.Handler {
+	withToken := verifier(authenticator(s.activeSession(next)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(apiKeyHeader)
+		if key == "" {
+			withToken.ServeHTTP(w, r)
+			return
+		}
+
+		apiKey, err := s.store.CheckAPIKey(r.Context(), hashToken(key))
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				httperr.Status(w, r, http.StatusUnauthorized)
+				return
+			}
+			writeStorageError(w, r, "checking api key", err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), apiKeyContextKey{}, apiKey)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireScope responds with 403 to the API keys without the scope, the access tokens have all of them
+func requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key := apiKeyFromContext(r.Context()); key != nil && !slices.Contains(key.Scopes, scope) {
+				httperr.Write(w, r, http.StatusForbidden, codeScopeRequired, "the api key has no "+scope+" scope")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// tokenOnly responds with 403 to the API keys
+func tokenOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if apiKeyFromContext(r.Context()) != nil {
+			httperr.Write(w, r, http.StatusForbidden, codeAccessTokenRequired, "the route requires an access token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func validateAPIKeyRequest(req *models.APIKeyRequest) (expiresAt time.Time, err error) {
+	if req.Name == "" || len(req.Name) > apiKeyMaxName {
+		return expiresAt, errors.New("the name is required and must be up to 100 characters")
+	}
+	if len(req.Scopes) == 0 {
+		return expiresAt, errors.New("at least one scope is required")
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(models.Scopes, scope) {
+			return expiresAt, errors.New("unknown scope " + scope)
+		}
+	}
+	slices.Sort(req.Scopes)
+	req.Scopes = slices.Compact(req.Scopes)
+
+	if req.ExpiresIn != "" {
+		ttl, er := time.ParseDuration(req.ExpiresIn)
+		if er != nil || ttl <= 0 {
+			return expiresAt, errors.New("expires_in must be a positive duration like 720h")
+		}
+		expiresAt = time.Now().Add(ttl)
+	}
+	return expiresAt, nil
+}
+
+func (s *ChiServer) createAPIKey(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := getUserID(r)
+	if err != nil {
+		httperr.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	// decoding request
+	var req models.APIKeyRequest
+	if err = httperr.Decode(r, &req); err != nil {
+		httperr.WriteError(w, r, err)
+		return
+	}
+
+	// validation
+	expiresAt, err := validateAPIKeyRequest(&req)
+	if err != nil {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, err.Error())
+		return
+	}
+
+	key, keyHash, err := newAPIKey()
+	if err != nil {
+		logger.Log.Error("failed to create an api key", zap.String("error", err.Error()))
+		httperr.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	// write into db
+	apiKey, err := s.store.CreateAPIKey(r.Context(), &models.APIKey{
+		UserID: userID,
+		Name:   req.Name,
+		Prefix: key[:apiKeyPrefixLen],
+		Scopes: req.Scopes,
+	}, keyHash, expiresAt)
+	if err != nil {
+		writeStorageError(w, r, "writing into db", err)
+		return
+	}
+	apiKey.Key = key
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	enc := json.NewEncoder(w)
+	if err = enc.Encode(apiKey); err != nil {
+		logger.Log.Info("error encoding response", zap.String("error", err.Error()))
+	}
+}
+
+func (s *ChiServer) getAPIKeys(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := getUserID(r)
+	if err != nil {
+		httperr.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	// reading from db
+	keys, err := s.store.GetUserAPIKeys(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeStorageError(w, r, "reading from db", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err = enc.Encode(keys); err != nil {
+		logger.Log.Info("error encoding response", zap.String("error", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *ChiServer) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := getUserID(r)
+	if err != nil {
+		httperr.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := urlParamID(r, "id")
+	if err != nil {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, err.Error())
+		return
+	}
+
+	// write into db
+	err = s.store.RevokeAPIKey(r.Context(), userID, id)
+	if err != nil {
+		writeStorageError(w, r, "writing into db", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
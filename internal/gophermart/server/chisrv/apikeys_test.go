@@ -0,0 +1,115 @@
+package chisrv
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/zasuchilas/gophermart/internal/gophermart/config"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// apiKeyStore knows the active keys by their hashes and the sessions of sessionStore
+type apiKeyStore struct {
+	sessionStore
+	keys map[string]*models.APIKey
+}
+
+func (m *apiKeyStore) CheckAPIKey(_ context.Context, keyHash string) (*models.APIKey, error) {
+	key, ok := m.keys[keyHash]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return key, nil
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	config.SecretKey = "testsecretkey"
+	config.AccessTokenTTL = time.Minute
+	config.CSRFProtection = true
+	InitJWT()
+
+	s := &ChiServer{store: &apiKeyStore{keys: map[string]*models.APIKey{
+		hashToken("gm_reader"): {ID: 1, UserID: 7, Scopes: []string{models.ScopeOrdersRead}},
+		hashToken("gm_writer"): {ID: 2, UserID: 7, Scopes: []string{models.ScopeOrdersRead, models.ScopeOrdersWrite}},
+	}}}
+	// responds with the user of the request
+	user := func(w http.ResponseWriter, r *http.Request) {
+		userID, err := getUserID(r)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(strconv.FormatInt(userID, 10)))
+	}
+	r := chi.NewRouter()
+	r.Use(s.authenticate)
+	r.Use(csrfProtect)
+	r.With(tokenOnly).Post("/api-keys", user)
+	r.With(requireScope(models.ScopeOrdersRead)).Get("/orders", user)
+	r.With(requireScope(models.ScopeOrdersWrite)).Post("/orders", user)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		token  string
+		status int
+		user   string
+	}{
+		{"key within scope", http.MethodGet, "/orders", "gm_reader", "", http.StatusOK, "7"},
+		{"key out of scope", http.MethodPost, "/orders", "gm_reader", "", http.StatusForbidden, ""},
+		{"key with scope", http.MethodPost, "/orders", "gm_writer", "", http.StatusOK, "7"},
+		{"key on token route", http.MethodPost, "/api-keys", "gm_writer", "", http.StatusForbidden, ""},
+		{"unknown key", http.MethodGet, "/orders", "gm_unknown", "", http.StatusUnauthorized, ""},
+		{"token on scoped route", http.MethodPost, "/orders", "", makeToken(1, 1, models.RoleUser), http.StatusOK, "1"},
+		{"token on token route", http.MethodPost, "/api-keys", "", makeToken(1, 1, models.RoleUser), http.StatusOK, "1"},
+		{"no credentials", http.MethodGet, "/orders", "", "", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.key != "" {
+				req.Header.Set(apiKeyHeader, tt.key)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.user != "" && w.Body.String() != tt.user {
+				t.Errorf("user = %s, want %s", w.Body.String(), tt.user)
+			}
+		})
+	}
+}
+
+func TestValidateAPIKeyRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     models.APIKeyRequest
+		wantErr bool
+	}{
+		{"valid", models.APIKeyRequest{Name: "pos", Scopes: []string{models.ScopeOrdersWrite}}, false},
+		{"expiring", models.APIKeyRequest{Name: "pos", Scopes: []string{models.ScopeBalanceRead}, ExpiresIn: "720h"}, false},
+		{"no name", models.APIKeyRequest{Scopes: []string{models.ScopeOrdersWrite}}, true},
+		{"no scopes", models.APIKeyRequest{Name: "pos"}, true},
+		{"unknown scope", models.APIKeyRequest{Name: "pos", Scopes: []string{"admin"}}, true},
+		{"negative expiration", models.APIKeyRequest{Name: "pos", Scopes: []string{models.ScopeOrdersRead}, ExpiresIn: "-1h"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := validateAPIKeyRequest(&tt.req); (err != nil) != tt.wantErr {
+				t.Errorf("validateAPIKeyRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package chisrv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/theplant/luhn"
+	"github.com/zasuchilas/gophermart/internal/gophermart/config"
+	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/pkg/httperr"
+	"go.uber.org/zap"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// the longest order number line in a batch body
+const maxBatchLineSize = 64
+
+// loadOrdersBatch registers many order numbers at once. The body is a JSON array
+// (Content-Type: application/json) or newline separated numbers (any other content type).
+// The response lists the upload result of every number in the order of the request.
+func (s *ChiServer) loadOrdersBatch(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := getUserID(r)
+	if err != nil {
+		httperr.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	// decoding request
+	r.Body = http.MaxBytesReader(w, r.Body, int64(config.BatchMaxSize*maxBatchLineSize))
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		httperr.WriteError(w, r, httperr.ReadError(err))
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var orderNums []string
+	if mediaType == "application/json" {
+		orderNums, err = parseBatchJSON(body)
+	} else {
+		orderNums, err = parseBatchText(body)
+	}
+	if err != nil {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, err.Error())
+		return
+	}
+
+	// validation
+	if len(orderNums) == 0 {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, "the batch is empty")
+		return
+	}
+	if len(orderNums) > config.BatchMaxSize {
+		httperr.Write(w, r, http.StatusRequestEntityTooLarge, httperr.CodeBodyTooLarge,
+			fmt.Sprintf("the batch is larger than %d numbers", config.BatchMaxSize))
+		return
+	}
+
+	results := make([]*models.OrderUploadResult, len(orderNums))
+	valid := make([]string, 0, len(orderNums))
+	seen := make(map[string]bool, len(orderNums))
+	for i, orderNum := range orderNums {
+		results[i] = &models.OrderUploadResult{OrderNum: orderNum}
+		if !validOrderNumber(orderNum) {
+			results[i].Result = models.OrderUploadInvalid
+			continue
+		}
+		if !seen[orderNum] {
+			seen[orderNum] = true
+			valid = append(valid, orderNum)
+		}
+	}
+
+	// writing into db
+	if len(valid) > 0 {
+		stored, er := s.store.RegisterOrders(r.Context(), userID, valid)
+		if er != nil {
+			writeStorageError(w, r, "writing into db", er)
+			return
+		}
+		// the repeated numbers of the batch are already uploaded by the first occurrence
+		accepted := make(map[string]bool, len(stored))
+		for _, res := range results {
+			if res.Result != "" {
+				continue
+			}
+			res.Result = stored[res.OrderNum]
+			if res.Result == models.OrderUploadAccepted {
+				if accepted[res.OrderNum] {
+					res.Result = models.OrderUploadAlreadyUploaded
+				}
+				accepted[res.OrderNum] = true
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err = enc.Encode(results); err != nil {
+		logger.Log.Info("error encoding response", zap.String("error", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// parseBatchJSON accepts the numbers as JSON strings or JSON numbers
+func parseBatchJSON(body []byte) ([]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var items []any
+	if err := dec.Decode(&items); err != nil {
+		return nil, errors.New("the body must be a JSON array of order numbers")
+	}
+	orderNums := make([]string, 0, len(items))
+	for _, item := range items {
+		switch v := item.(type) {
+		case string:
+			orderNums = append(orderNums, strings.TrimSpace(v))
+		case json.Number:
+			orderNums = append(orderNums, v.String())
+		default:
+			return nil, errors.New("the order numbers must be strings or numbers")
+		}
+	}
+	return orderNums, nil
+}
+
+func parseBatchText(body []byte) ([]string, error) {
+	orderNums := make([]string, 0)
+	sc := bufio.NewScanner(bytes.NewReader(body))
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" {
+			orderNums = append(orderNums, line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return orderNums, nil
+}
+
+// orderNumberPattern is the digits only order number without a sign and leading zeros,
+// so a number has the only string form in the db
+var orderNumberPattern = regexp.MustCompile(`^[1-9][0-9]*$`)
+
+// validOrderNumber checks the order number form and the luna algorithm
+// https://ru.wikipedia.org/wiki/Алгоритм_Луна
+// https://goodcalculators.com/luhn-algorithm-calculator/?Num=18
+func validOrderNumber(orderNum string) bool {
+	if !orderNumberPattern.MatchString(orderNum) {
+		return false
+	}
+	number, err := strconv.Atoi(orderNum)
+	if err != nil {
+		return false // too long
+	}
+	return luhn.Valid(number)
+}
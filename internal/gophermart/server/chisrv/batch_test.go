@@ -0,0 +1,52 @@
+package chisrv
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseBatch(t *testing.T) {
+	got, err := parseBatchJSON([]byte(`["12345678903", 79927398713, " 4561261212345467 "]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"12345678903", "79927398713", "4561261212345467"}; !slices.Equal(got, want) {
+		t.Errorf("parseBatchJSON() = %v, want %v", got, want)
+	}
+
+	if _, err = parseBatchJSON([]byte(`[{"number": "12345678903"}]`)); err == nil {
+		t.Error("parseBatchJSON() accepts objects")
+	}
+	if _, err = parseBatchJSON([]byte(`"12345678903"`)); err == nil {
+		t.Error("parseBatchJSON() accepts a single string")
+	}
+
+	got, err = parseBatchText([]byte("12345678903\r\n\n  79927398713\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"12345678903", "79927398713"}; !slices.Equal(got, want) {
+		t.Errorf("parseBatchText() = %v, want %v", got, want)
+	}
+}
+
+func TestValidOrderNumber(t *testing.T) {
+	for orderNum, want := range map[string]bool{
+		"12345678903":             true,
+		"79927398713":             true,
+		"79927398710":             false,
+		"-79927398713":            false,
+		"+79927398713":            false,
+		"079927398713":            false,
+		" 79927398713":            false,
+		"0":                       false,
+		"99999999999999999999999": false,
+		"7992739871x":             false,
+		"":                        false,
+		"4561261212345467":        true,
+	} {
+		if got := validOrderNumber(orderNum); got != want {
+			t.Errorf("validOrderNumber(%q) = %v, want %v", orderNum, got, want)
+		}
+	}
+}
@@ -3,10 +3,14 @@ package chisrv
 import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/go-chi/jwtauth/v5"
 	"github.com/zasuchilas/gophermart/internal/gophermart/config"
+	"github.com/zasuchilas/gophermart/internal/gophermart/events"
 	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/notifier"
 	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"github.com/zasuchilas/gophermart/pkg/amount"
+	"github.com/zasuchilas/gophermart/pkg/httperr"
 	"go.uber.org/zap"
 	"net/http"
 	"sync"
@@ -14,12 +18,16 @@ import (
 
 type ChiServer struct {
 	store     storage.Storage
+	events    *events.Hub
+	notifier  notifier.Notifier
 	waitGroup *sync.WaitGroup
 }
 
-func New(s storage.Storage, wg *sync.WaitGroup) *ChiServer {
+func New(s storage.Storage, hub *events.Hub, n notifier.Notifier, wg *sync.WaitGroup) *ChiServer {
 	srv := &ChiServer{
 		store:     s,
+		events:    hub,
+		notifier:  n,
 		waitGroup: wg,
 	}
 	return srv
@@ -39,24 +47,115 @@ func (s *ChiServer) router() chi.Router {
 	r := chi.NewRouter()
 
 	// middlewares
+	r.Use(httperr.RequestID)
 	r.Use(middleware.Logger)
+	r.Use(httperr.LimitBody(int64(config.MaxBodySize)))
 	r.Use(middleware.AllowContentEncoding("deflate", "gzip"))
 	r.Use(middleware.Compress(9, "application/json", "text/plain")) // if Accept-Encoding header (gzip, deflate)
+	r.Use(amount.AsStrings)                                         // if Accept: application/json; amounts=string
+
+	r.NotFound(httperr.NotFound)
+	r.MethodNotAllowed(httperr.MethodNotAllowed)
 
 	// routes
-	r.Get("/", s.home)
-	r.Post("/api/user/register", s.register)
-	r.Post("/api/user/login", s.login)
+	r.Group(func(r chi.Router) {
+		r.Use(validateRequests)
+
+		r.Get("/", s.home)
+		r.Get("/openapi.json", apiSpec.ServeHTTP)
+		r.Get("/.well-known/jwks.json", s.jwks)
+		r.Post("/api/user/register", s.register)
+		r.Post("/api/user/login", s.login)
+		r.Post("/api/user/token/refresh", s.refreshToken)
+		r.Post("/api/user/password/reset", s.requestPasswordReset)
+		r.Post("/api/user/password/reset/confirm", s.confirmPasswordReset)
+	})
 
 	r.Group(func(r chi.Router) {
-		r.Use(jwtauth.Verifier(tokenAuth))
-		r.Use(jwtauth.Authenticator(tokenAuth))
-
-		r.Post("/api/user/orders", s.loadNewOrder)
-		r.Get("/api/user/orders", s.getUserOrders)
-		r.Get("/api/user/balance", s.getUserBalance)
-		r.Post("/api/user/balance/withdraw", s.withdrawFromBalance)
-		r.Get("/api/user/withdrawals", s.getWithdrawalList)
+		r.Use(s.authenticate)
+		r.Use(csrfProtect)
+		r.Use(validateRequests)
+
+		// the account, its keys and webhooks are managed with the access tokens only
+		r.Group(func(r chi.Router) {
+			r.Use(tokenOnly)
+
+			r.Post("/api/user/logout", s.logout)
+			r.Post("/api/user/logout/all", s.logoutEverywhere)
+			r.Post("/api/user/password", s.changePassword)
+			r.Delete("/api/user", s.deleteUser)
+
+			r.Post("/api/user/api-keys", s.createAPIKey)
+			r.Get("/api/user/api-keys", s.getAPIKeys)
+			r.Delete("/api/user/api-keys/{id}", s.revokeAPIKey)
+
+			r.Post("/api/user/webhooks", s.createWebhook)
+			r.Get("/api/user/webhooks", s.getWebhooks)
+			r.Delete("/api/user/webhooks/{id}", s.deleteWebhook)
+			r.Get("/api/user/webhooks/{id}/deliveries", s.getWebhookDeliveries)
+			r.Post("/api/user/webhooks/{id}/deliveries/{deliveryID}/redeliver", s.redeliverWebhook)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(requireScope(models.ScopeOrdersWrite))
+
+			r.With(s.idempotent).Post("/api/user/orders", s.loadNewOrder)
+			r.With(s.idempotent).Post("/api/user/orders/batch", s.loadOrdersBatch)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(requireScope(models.ScopeOrdersRead))
+
+			r.Get("/api/user/orders", s.getUserOrders)
+			r.Get("/api/user/orders/{number}", s.getUserOrder)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(requireScope(models.ScopeBalanceRead))
+
+			r.Get("/api/user/balance", s.getUserBalance)
+			r.Get("/api/user/withdrawals", s.getWithdrawalList)
+			r.Get("/api/user/holds", s.getHolds)
+			r.Get("/api/user/ledger", s.getLedger)
+			r.Get("/api/user/events", s.streamEvents)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(requireScope(models.ScopeBalanceWrite))
+
+			r.With(s.idempotent).Post("/api/user/balance/withdraw", s.withdrawFromBalance)
+			r.Post("/api/user/withdrawals/{id}/cancel", s.cancelWithdrawal)
+			r.With(s.idempotent).Post("/api/user/holds", s.createHold)
+			r.Post("/api/user/holds/{id}/capture", s.captureHold)
+			r.Post("/api/user/holds/{id}/release", s.releaseHold)
+		})
+	})
+
+	r.Route("/api/admin", func(r chi.Router) {
+		r.Use(s.authenticate)
+		r.Use(tokenOnly)
+		r.Use(csrfProtect)
+		r.Use(validateRequests)
+
+		r.Group(func(r chi.Router) {
+			r.Use(requireRole(models.RoleSupport))
+
+			r.Get("/users", s.adminGetUsers)
+			r.Get("/users/{id}", s.adminGetUser)
+			r.Get("/users/{id}/orders", s.adminGetUserOrders)
+			r.Get("/users/{id}/withdrawals", s.adminGetUserWithdrawals)
+			r.Get("/users/{id}/balance", s.adminGetUserBalance)
+			r.Get("/audit", s.adminGetAuditLog)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(requireRole(models.RoleAdmin))
+
+			r.Delete("/users/{id}", s.adminDeleteUser)
+			r.Post("/users/{id}/restore", s.adminRestoreUser)
+			r.Post("/users/{id}/adjustments", s.adminAdjustBalance)
+			r.Post("/orders/{number}/repoll", s.adminRepollOrder)
+		})
 	})
 
 	return r
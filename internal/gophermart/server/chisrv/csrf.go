@@ -0,0 +1,63 @@
+package chisrv
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/zasuchilas/gophermart/internal/gophermart/config"
+	"github.com/zasuchilas/gophermart/pkg/httperr"
+	"net/http"
+)
+
+// Double-submit protection: the frontend copies the "csrf_token" cookie into the X-CSRF-Token header,
+// a cross-site form cannot read the cookie. The requests with the Authorization or X-API-Key header are not
+// sent by browsers on their own and are not checked.
+
+const (
+	csrfCookieName = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+func newCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// csrfProtect checks the state-changing requests authorized by the cookie
+func csrfProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !config.CSRFProtection || jwtauth.TokenFromHeader(r) != "" || apiKeyFromContext(r.Context()) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !csrfTokenMatches(r) {
+			writeCSRFMismatch(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// csrfTokenMatches compares the X-CSRF-Token header with the csrf_token cookie
+func csrfTokenMatches(r *http.Request) bool {
+	cookie, err := r.Cookie(csrfCookieName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.Header.Get(csrfHeaderName))) == 1
+}
+
+func writeCSRFMismatch(w http.ResponseWriter, r *http.Request) {
+	httperr.Write(w, r, http.StatusForbidden, codeCSRFMismatch, "csrf token mismatch")
+}
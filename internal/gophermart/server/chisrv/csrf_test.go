@@ -0,0 +1,100 @@
+package chisrv
+
+import (
+	"context"
+	"github.com/zasuchilas/gophermart/internal/gophermart/config"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSRFProtect(t *testing.T) {
+	config.CSRFProtection = true
+	h := csrfProtect(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name   string
+		method string
+		bearer bool
+		cookie string
+		header string
+		status int
+	}{
+		{"cookie without header", http.MethodPost, false, "token", "", http.StatusForbidden},
+		{"cookie with another header", http.MethodPost, false, "token", "other", http.StatusForbidden},
+		{"cookie with header", http.MethodPost, false, "token", "token", http.StatusOK},
+		{"no csrf cookie", http.MethodPost, false, "", "", http.StatusForbidden},
+		{"bearer token", http.MethodPost, true, "", "", http.StatusOK},
+		{"safe method", http.MethodGet, false, "token", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/user/orders", nil)
+			req.AddCookie(&http.Cookie{Name: jwtCookieName, Value: "jwt"})
+			if tt.bearer {
+				req.Header.Set("Authorization", "Bearer jwt")
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				req.Header.Set(csrfHeaderName, tt.header)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+// refreshStore knows no sessions, the other methods are not used
+type refreshStore struct {
+	storage.Storage
+}
+
+func (m *refreshStore) RefreshSession(_ context.Context, _, _ string, _ time.Time) (*models.Session, error) {
+	return nil, storage.ErrNotFound
+}
+
+func TestRefreshTokenCSRF(t *testing.T) {
+	config.CSRFProtection = true
+	s := &ChiServer{store: &refreshStore{}}
+
+	tests := []struct {
+		name   string
+		cookie bool
+		header string
+		body   string
+		status int
+	}{
+		{"cookie without header", true, "", "", http.StatusForbidden},
+		{"cookie with another header", true, "other", "", http.StatusForbidden},
+		{"cookie with header", true, "token", "", http.StatusUnauthorized},
+		{"body", false, "", `{"refresh_token": "unknown"}`, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/user/token/refresh", strings.NewReader(tt.body))
+			if tt.cookie {
+				req.AddCookie(&http.Cookie{Name: refreshCookieName, Value: "unknown"})
+				req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: "token"})
+			}
+			if tt.header != "" {
+				req.Header.Set(csrfHeaderName, tt.header)
+			}
+			w := httptest.NewRecorder()
+			s.refreshToken(w, req)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
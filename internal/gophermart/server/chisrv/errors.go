@@ -0,0 +1,70 @@
+package chisrv
+
+import (
+	"errors"
+	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"github.com/zasuchilas/gophermart/pkg/httperr"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+// The codes of the gophermart errors, the generic ones are in httperr
+const (
+	codeInvalidOrderNumber  = "invalid_order_number"
+	codeUnknownCurrency     = "unknown_currency"
+	codeLoginTaken          = "login_taken"
+	codeInvalidCredentials  = "invalid_credentials"
+	codeWrongPassword       = "wrong_password"
+	codeInvalidResetToken   = "invalid_reset_token"
+	codeLoginLocked         = "login_locked"
+	codeCSRFMismatch        = "csrf_token_mismatch"
+	codeRoleRequired        = "role_required"
+	codeRoleChanged         = "role_changed"
+	codeScopeRequired       = "scope_required"
+	codeAccessTokenRequired = "access_token_required"
+)
+
+// storageErrors maps the storage errors to the API errors,
+// the handlers check the errors meaning something else for them (a not found list is 204) before
+var storageErrors = []struct {
+	err error
+	api *httperr.Error
+}{
+	{storage.ErrNotFound, httperr.New(http.StatusNotFound, httperr.CodeNotFound, "not found")},
+	{storage.ErrGone, httperr.New(http.StatusConflict, "user_deleted", "the user is deleted")},
+	{storage.ErrBadRequest, httperr.New(http.StatusBadRequest, httperr.CodeBadRequest, "bad request")},
+	{storage.ErrNumberAdded, httperr.New(http.StatusConflict, "order_number_taken", "the order number is uploaded by another user")},
+	{storage.ErrNotEnoughFunds, httperr.New(http.StatusPaymentRequired, "not_enough_funds", "not enough funds on the balance")},
+	{storage.ErrLimitExceeded, httperr.New(http.StatusConflict, "limit_exceeded", "the limit of the user is exceeded")},
+	{storage.ErrReversed, httperr.New(http.StatusConflict, "withdrawal_reversed", "the withdrawal is already reversed")},
+	{storage.ErrWindowClosed, httperr.New(http.StatusForbidden, "cancel_window_closed", "the withdrawal can no longer be canceled")},
+	{storage.ErrHoldNotActive, httperr.New(http.StatusConflict, "hold_not_active", "the hold is already captured, released or expired")},
+	{storage.ErrCurrencyMismatch, httperr.New(http.StatusUnprocessableEntity, "currency_mismatch", "the currency does not match the account currency")},
+	{storage.ErrSessionRevoked, httperr.New(http.StatusUnauthorized, "session_revoked", "the session is revoked")},
+	{storage.ErrOrderProcessed, httperr.New(http.StatusConflict, "order_processed", "the order is processed, the accrual is credited already")},
+	{storage.ErrNotDeleted, httperr.New(http.StatusConflict, "user_not_deleted", "the user is not deleted")},
+	{storage.ErrIdempotencyKeyReused, httperr.New(http.StatusUnprocessableEntity, "idempotency_key_reused", "the idempotency key was used for another request")},
+	{storage.ErrIdempotencyKeyInProgress, httperr.New(http.StatusConflict, "idempotency_key_in_progress", "the request with the idempotency key is in progress")},
+}
+
+// storageError returns the API error of the storage error, nil for the unexpected ones
+func storageError(err error) *httperr.Error {
+	for _, e := range storageErrors {
+		if errors.Is(err, e.err) {
+			return e.api
+		}
+	}
+	return nil
+}
+
+// writeStorageError responds with the API error of the storage error,
+// the unexpected errors are logged with the operation and respond with 500
+func writeStorageError(w http.ResponseWriter, r *http.Request, op string, err error) {
+	if e := storageError(err); e != nil {
+		httperr.WriteError(w, r, e)
+		return
+	}
+	logger.Log.Info(op, zap.String("error", err.Error()))
+	httperr.Status(w, r, http.StatusInternalServerError)
+}
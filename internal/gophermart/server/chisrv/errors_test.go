@@ -0,0 +1,60 @@
+package chisrv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/zasuchilas/gophermart/internal/gophermart/config"
+	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteStorageError(t *testing.T) {
+	config.LogLevel = "error"
+	logger.Init()
+
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"not enough funds", storage.ErrNotEnoughFunds, http.StatusPaymentRequired, "not_enough_funds"},
+		{"wrapped", fmt.Errorf("withdrawing: %w", storage.ErrNumberAdded), http.StatusConflict, "order_number_taken"},
+		{"idempotency key", storage.ErrIdempotencyKeyReused, http.StatusUnprocessableEntity, "idempotency_key_reused"},
+		{"unexpected", errors.New("connection refused"), http.StatusInternalServerError, "internal_error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeStorageError(w, httptest.NewRequest(http.MethodGet, "/", nil), "testing", tt.err)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			var resp struct {
+				Error struct {
+					Code string `json:"code"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decoding envelope %q: %v", w.Body.String(), err)
+			}
+			if resp.Error.Code != tt.code {
+				t.Errorf("code = %s, want %s", resp.Error.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestStorageErrorsAreDistinct(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, e := range storageErrors {
+		if seen[e.api.Code] {
+			t.Errorf("the code %s is used twice", e.api.Code)
+		}
+		seen[e.api.Code] = true
+	}
+}
@@ -0,0 +1,119 @@
+package chisrv
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/pkg/amount"
+	"github.com/zasuchilas/gophermart/pkg/httperr"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	eventsRetry     = 3 * time.Second
+	eventsHeartbeat = 15 * time.Second
+)
+
+// streamEvents is the Server-Sent Events stream of the user order and balance changes.
+// The events missed after the Last-Event-ID are replayed from the db on reconnection.
+// The ids of the user events grow in the commit order, so an id not greater than the last sent one
+// is a repeat (of the replay or of the catch-up of the hub) and is skipped.
+func (s *ChiServer) streamEvents(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := getUserID(r)
+	if err != nil {
+		httperr.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		logger.Log.Info("streaming is not supported by the response writer")
+		httperr.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	var lastID int64
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		lastID, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || lastID < 0 {
+			httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, "the Last-Event-ID must be an event id")
+			return
+		}
+	}
+
+	// subscribing before the replay, so nothing is lost in between
+	events, unsubscribe := s.events.Subscribe(userID)
+	defer unsubscribe()
+
+	quoteAmounts := amount.WantsStrings(r)
+	lastSent := lastID
+	var missed []*models.Event
+	if lastID > 0 {
+		missed, err = s.store.GetUserEvents(r.Context(), userID, lastID)
+		if err != nil {
+			writeStorageError(w, r, "reading from db", err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	_, _ = fmt.Fprintf(w, "retry: %d\n\n", eventsRetry.Milliseconds())
+	for _, e := range missed {
+		if err = writeEvent(w, e, quoteAmounts); err != nil {
+			return
+		}
+		lastSent = e.ID
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				// the subscriber fell behind or the server stops, the client reconnects
+				return
+			}
+			if e.ID <= lastSent {
+				continue
+			}
+			if err = writeEvent(w, e, quoteAmounts); err != nil {
+				return
+			}
+			lastSent = e.ID
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err = fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e *models.Event, quoteAmounts bool) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		logger.Log.Info("error encoding event", zap.String("error", err.Error()))
+		return err
+	}
+	if quoteAmounts {
+		data = amount.QuoteDecimals(data)
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
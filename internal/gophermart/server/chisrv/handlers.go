@@ -4,17 +4,19 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/Rhymond/go-money"
-	"github.com/theplant/luhn"
+	"github.com/go-chi/chi/v5"
+	"github.com/zasuchilas/gophermart/internal/common"
+	"github.com/zasuchilas/gophermart/internal/gophermart/config"
 	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
 	"github.com/zasuchilas/gophermart/internal/gophermart/models"
 	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"github.com/zasuchilas/gophermart/pkg/httperr"
 	"github.com/zasuchilas/gophermart/pkg/passhash"
 	"go.uber.org/zap"
 	"io"
 	"net/http"
-	"strconv"
-	"time"
 )
 
 func (s *ChiServer) home(w http.ResponseWriter, _ *http.Request) {
@@ -27,62 +29,74 @@ func (s *ChiServer) register(w http.ResponseWriter, r *http.Request) {
 
 	// decoding request
 	var req models.RegisterRequest
-	dec := json.NewDecoder(r.Body)
-	if err := dec.Decode(&req); err != nil {
-		logger.Log.Debug("cannot decode request JSON body", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
+	if err := httperr.Decode(r, &req); err != nil {
+		httperr.WriteError(w, r, err)
 		return
 	}
 
 	// validation
 	if len(req.Login) < 3 {
-		http.Error(w, "login is shorter than 3", http.StatusBadRequest)
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, "login is shorter than 3")
 		return
 	}
 	if len(req.Password) < 6 {
-		http.Error(w, "password is shorter than 6", http.StatusBadRequest)
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, "password is shorter than 6")
+		return
+	}
+	currency := config.Currency
+	if req.Currency != "" {
+		currency = req.Currency
+	}
+	if !common.ValidCurrency(currency) {
+		httperr.Write(w, r, http.StatusBadRequest, codeUnknownCurrency, "unknown currency "+currency)
 		return
 	}
 
 	// make password hash
 	pass, err := passhash.HashPassword(req.Password)
 	if err != nil {
-		http.Error(w, "failed to create a password hash", http.StatusInternalServerError)
+		logger.Log.Error("failed to create a password hash", zap.String("error", err.Error()))
+		httperr.Status(w, r, http.StatusInternalServerError)
 		return
 	}
 
 	// write into db
-	userID, err := s.store.Register(r.Context(), req.Login, pass)
+	userID, err := s.store.Register(r.Context(), req.Login, pass, currency)
 	if userID == 0 {
-		w.WriteHeader(http.StatusConflict)
+		httperr.Write(w, r, http.StatusConflict, codeLoginTaken, "the login is already taken")
 		return
 	}
 	if err != nil {
 		logger.Log.Error("failed to write new user into db", zap.String("error", err.Error()))
-		w.WriteHeader(http.StatusInternalServerError)
+		httperr.Status(w, r, http.StatusInternalServerError)
 		return
 	}
 
 	// authorize user
-	setJWTCookie(w, userID)
-
-	w.WriteHeader(http.StatusOK)
+	if err = s.startSession(w, r, userID, models.RoleUser); err != nil {
+		logger.Log.Error("failed to start a session", zap.String("error", err.Error()))
+		httperr.Status(w, r, http.StatusInternalServerError)
+		return
+	}
 }
 
 func (s *ChiServer) login(w http.ResponseWriter, r *http.Request) {
 
 	// decoding request
 	var req models.LoginRequest
-	dec := json.NewDecoder(r.Body)
-	if err := dec.Decode(&req); err != nil {
-		logger.Log.Info("cannot decode request JSON body", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
+	if err := httperr.Decode(r, &req); err != nil {
+		httperr.WriteError(w, r, err)
 		return
 	}
 
 	// validation
 	if len(req.Login) < 3 || len(req.Password) < 6 {
-		w.WriteHeader(http.StatusBadRequest)
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, "login is shorter than 3 or password is shorter than 6")
+		return
+	}
+
+	// brute-force protection, the attempt is counted before the password hash is checked
+	if s.loginLocked(w, r, req.Login) {
 		return
 	}
 
@@ -90,65 +104,63 @@ func (s *ChiServer) login(w http.ResponseWriter, r *http.Request) {
 	loginData, err := s.store.GetLoginData(r.Context(), req.Login, req.Password)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			w.WriteHeader(http.StatusUnauthorized)
+			httperr.Write(w, r, http.StatusUnauthorized, codeInvalidCredentials, "wrong login or password")
 			return
 		}
-		logger.Log.Info("cannot get login data from db", zap.String("error", err.Error()))
-		w.WriteHeader(http.StatusInternalServerError)
+		writeStorageError(w, r, "cannot get login data from db", err)
 		return
 	}
 
 	// check password hash
 	ok := passhash.CheckPasswordHash(req.Password, loginData.PasswordHash)
 	if !ok {
-		w.WriteHeader(http.StatusUnauthorized)
+		httperr.Write(w, r, http.StatusUnauthorized, codeInvalidCredentials, "wrong login or password")
 		return
 	}
+	s.loginSucceeded(r, req.Login)
 
-	// authorize user
-	setJWTCookie(w, loginData.UserID)
-
-	w.WriteHeader(http.StatusOK)
-}
+	// upgrade the hash made with the outdated parameters, the login goes on if it fails
+	if passhash.NeedsRehash(loginData.PasswordHash) {
+		pass, er := passhash.HashPassword(req.Password)
+		if er == nil {
+			er = s.store.UpdatePasswordHash(r.Context(), loginData.UserID, loginData.PasswordHash, pass)
+		}
+		if er != nil {
+			logger.Log.Info("upgrading password hash", zap.String("error", er.Error()))
+		}
+	}
 
-func setJWTCookie(w http.ResponseWriter, userID int64) {
-	token := makeToken(userID)
-	http.SetCookie(w, &http.Cookie{
-		//HttpOnly: true,
-		Expires: time.Now().Add(100 * 24 * time.Hour),
-		//SameSite: http.SameSiteLaxMode,
-		// Secure: true,
-		Name:  "jwt", // Must be named "jwt" or else the token cannot be searched for by jwtauth.Verifier.
-		Value: token,
-	})
+	// authorize user
+	if err = s.startSession(w, r, loginData.UserID, loginData.Role); err != nil {
+		logger.Log.Error("failed to start a session", zap.String("error", err.Error()))
+		httperr.Status(w, r, http.StatusInternalServerError)
+		return
+	}
 }
 
 func (s *ChiServer) loadNewOrder(w http.ResponseWriter, r *http.Request) {
 
 	userID, err := getUserID(r)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
+		httperr.Status(w, r, http.StatusUnauthorized)
 		return
 	}
 
 	// decoding request
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		httperr.WriteError(w, r, httperr.ReadError(err))
 		return
 	}
 	orderNum := string(body)
 
 	// luna validation
-	// https://ru.wikipedia.org/wiki/Алгоритм_Луна
-	// https://goodcalculators.com/luhn-algorithm-calculator/?Num=18
-	number, err := strconv.Atoi(string(orderNum))
-	if err != nil {
-		http.Error(w, "the order number must be a number string", http.StatusBadRequest)
+	if !orderNumberPattern.MatchString(orderNum) {
+		httperr.Write(w, r, http.StatusBadRequest, codeInvalidOrderNumber, "the order number must be a number string")
 		return
 	}
-	if ok := luhn.Valid(number); !ok {
-		http.Error(w, "luna validation failed", http.StatusUnprocessableEntity)
+	if !validOrderNumber(orderNum) {
+		httperr.Write(w, r, http.StatusUnprocessableEntity, codeInvalidOrderNumber, "luna validation failed")
 		return
 	}
 
@@ -159,11 +171,7 @@ func (s *ChiServer) loadNewOrder(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusOK)
 			return
 		}
-		if errors.Is(err, storage.ErrNumberAdded) {
-			w.WriteHeader(http.StatusConflict)
-		}
-		logger.Log.Info("writing into db", zap.String("error", err.Error()))
-		w.WriteHeader(http.StatusInternalServerError)
+		writeStorageError(w, r, "writing into db", err)
 		return
 	}
 
@@ -175,26 +183,33 @@ func (s *ChiServer) getUserOrders(w http.ResponseWriter, r *http.Request) {
 
 	userID, err := getUserID(r)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
+		httperr.Status(w, r, http.StatusUnauthorized)
 		return
 	}
 
 	logger.Log.Debug("userID received", zap.Int64("userID", userID))
 
+	// pagination and filters
+	q, err := parseListQuery(r, orderStatuses)
+	if err != nil {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, err.Error())
+		return
+	}
+
 	// reading from db
-	orders, err := s.store.GetUserOrders(r.Context(), userID)
+	orders, next, err := s.store.GetUserOrders(r.Context(), userID, q)
 	if err != nil {
 		if errors.Is(err, storage.ErrNotFound) {
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
-		logger.Log.Info("reading from db", zap.String("error", err.Error()))
-		w.WriteHeader(http.StatusInternalServerError)
+		writeStorageError(w, r, "reading from db", err)
 		return
 	}
 
 	logger.Log.Debug("orders received from pg", zap.Any("orders", orders))
 
+	setNextCursor(w, next)
 	w.Header().Set("Content-Type", "application/json")
 	enc := json.NewEncoder(w)
 	if err = enc.Encode(orders); err != nil {
@@ -206,19 +221,43 @@ func (s *ChiServer) getUserOrders(w http.ResponseWriter, r *http.Request) {
 	logger.Log.Debug("handler finished the job")
 }
 
+func (s *ChiServer) getUserOrder(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := getUserID(r)
+	if err != nil {
+		httperr.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	// the orders of other users are not found as well
+	orderNum := chi.URLParam(r, "number")
+	order, err := s.store.GetUserOrder(r.Context(), userID, orderNum)
+	if err != nil {
+		writeStorageError(w, r, "reading from db", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err = enc.Encode(order); err != nil {
+		logger.Log.Info("error encoding response", zap.String("error", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
 func (s *ChiServer) getUserBalance(w http.ResponseWriter, r *http.Request) {
 
 	userID, err := getUserID(r)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
+		httperr.Status(w, r, http.StatusUnauthorized)
 		return
 	}
 
 	// reading from db
 	balance, err := s.store.GetUserBalance(r.Context(), userID)
 	if err != nil {
-		logger.Log.Info("reading from db", zap.String("error", err.Error()))
-		w.WriteHeader(http.StatusInternalServerError)
+		writeStorageError(w, r, "reading from db", err)
 		return
 	}
 
@@ -235,49 +274,44 @@ func (s *ChiServer) withdrawFromBalance(w http.ResponseWriter, r *http.Request)
 
 	userID, err := getUserID(r)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
+		httperr.Status(w, r, http.StatusUnauthorized)
 		return
 	}
 
 	// decoding request
 	var req models.WithdrawRequest
-	dec := json.NewDecoder(r.Body)
-	if err = dec.Decode(&req); err != nil {
-		logger.Log.Debug("cannot decode request JSON body", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
+	if err = httperr.Decode(r, &req); err != nil {
+		httperr.WriteError(w, r, err)
 		return
 	}
 
 	// luna validation
-	// https://ru.wikipedia.org/wiki/Алгоритм_Луна
-	// https://goodcalculators.com/luhn-algorithm-calculator/?Num=18
 	orderNum := req.Order
-	number, err := strconv.Atoi(orderNum)
-	if err != nil {
-		http.Error(w, "the order number must be a number string", http.StatusUnprocessableEntity)
-		return
-	}
-	if ok := luhn.Valid(number); !ok {
-		http.Error(w, "luna validation failed", http.StatusUnprocessableEntity)
+	if !validOrderNumber(orderNum) {
+		httperr.Write(w, r, http.StatusUnprocessableEntity, codeInvalidOrderNumber, "luna validation failed")
 		return
 	}
 
 	// money validation and transform
-	sum := money.NewFromFloat(req.Sum, money.RUB) //.Amount()
+	currency, err := s.sumCurrency(r, userID, req.Currency)
+	if err != nil {
+		if errors.Is(err, errUnknownCurrency) {
+			httperr.Write(w, r, http.StatusBadRequest, codeUnknownCurrency, err.Error())
+			return
+		}
+		writeStorageError(w, r, "reading from db", err)
+		return
+	}
+	sum := money.New(req.Sum.Minor(), currency)
 	if sum.IsZero() || sum.IsNegative() {
-		http.Error(w, "the sum must be a positive number", http.StatusBadRequest)
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, "the sum must be a positive number")
 		return
 	}
 
 	// write into db
 	err = s.store.WithdrawTransaction(r.Context(), userID, orderNum, sum)
 	if err != nil {
-		if errors.Is(err, storage.ErrNotEnoughFunds) {
-			w.WriteHeader(http.StatusPaymentRequired)
-			return
-		}
-		logger.Log.Info("writing into db", zap.String("error", err.Error()))
-		w.WriteHeader(http.StatusInternalServerError)
+		writeStorageError(w, r, "writing into db", err)
 		return
 	}
 
@@ -288,22 +322,29 @@ func (s *ChiServer) getWithdrawalList(w http.ResponseWriter, r *http.Request) {
 
 	userID, err := getUserID(r)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
+		httperr.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	// pagination and filters
+	q, err := parseListQuery(r, nil)
+	if err != nil {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, err.Error())
 		return
 	}
 
 	// reading from db
-	withdrawals, err := s.store.GetUserWithdrawals(r.Context(), userID)
+	withdrawals, next, err := s.store.GetUserWithdrawals(r.Context(), userID, q)
 	if err != nil {
 		if errors.Is(err, storage.ErrNotFound) {
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
-		logger.Log.Info("reading from db", zap.String("error", err.Error()))
-		w.WriteHeader(http.StatusInternalServerError)
+		writeStorageError(w, r, "reading from db", err)
 		return
 	}
 
+	setNextCursor(w, next)
 	w.Header().Set("Content-Type", "application/json")
 	enc := json.NewEncoder(w)
 	if err = enc.Encode(withdrawals); err != nil {
@@ -312,3 +353,74 @@ func (s *ChiServer) getWithdrawalList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+func (s *ChiServer) cancelWithdrawal(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := getUserID(r)
+	if err != nil {
+		httperr.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := urlParamID(r, "id")
+	if err != nil {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, err.Error())
+		return
+	}
+
+	// write into db
+	withdrawal, err := s.store.CancelWithdrawal(r.Context(), userID, id, config.CancelWindow)
+	if err != nil {
+		writeStorageError(w, r, "writing into db", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err = enc.Encode(withdrawal); err != nil {
+		logger.Log.Info("error encoding response", zap.String("error", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *ChiServer) getLedger(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := getUserID(r)
+	if err != nil {
+		httperr.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	// reading from db
+	entries, err := s.store.GetUserLedger(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeStorageError(w, r, "reading from db", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err = enc.Encode(entries); err != nil {
+		logger.Log.Info("error encoding response", zap.String("error", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+var errUnknownCurrency = errors.New("unknown currency")
+
+// sumCurrency returns the currency of the requested sum, it is the account currency if omitted
+func (s *ChiServer) sumCurrency(r *http.Request, userID int64, currency string) (string, error) {
+	if currency == "" {
+		return s.store.GetUserCurrency(r.Context(), userID)
+	}
+	if !common.ValidCurrency(currency) {
+		return "", fmt.Errorf("%w %s", errUnknownCurrency, currency)
+	}
+	return currency, nil
+}
@@ -0,0 +1,149 @@
+package chisrv
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Rhymond/go-money"
+	"github.com/zasuchilas/gophermart/internal/gophermart/config"
+	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"github.com/zasuchilas/gophermart/pkg/httperr"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (s *ChiServer) createHold(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := getUserID(r)
+	if err != nil {
+		httperr.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	// decoding request
+	var req models.HoldRequest
+	if err = httperr.Decode(r, &req); err != nil {
+		httperr.WriteError(w, r, err)
+		return
+	}
+
+	// luna validation
+	if !validOrderNumber(req.Order) {
+		httperr.Write(w, r, http.StatusUnprocessableEntity, codeInvalidOrderNumber, "luna validation failed")
+		return
+	}
+
+	// money validation and transform
+	currency, err := s.sumCurrency(r, userID, req.Currency)
+	if err != nil {
+		if errors.Is(err, errUnknownCurrency) {
+			httperr.Write(w, r, http.StatusBadRequest, codeUnknownCurrency, err.Error())
+			return
+		}
+		writeStorageError(w, r, "reading from db", err)
+		return
+	}
+	sum := money.New(req.Sum.Minor(), currency)
+	if sum.IsZero() || sum.IsNegative() {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, "the sum must be a positive number")
+		return
+	}
+
+	ttl := config.HoldTTL
+	if req.TTL != 0 {
+		ttl = time.Duration(req.TTL) * time.Second
+	}
+	if ttl <= 0 || ttl > config.HoldMaxTTL {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation,
+			"the ttl must be from 1 to "+strconv.Itoa(int(config.HoldMaxTTL.Seconds()))+" seconds")
+		return
+	}
+
+	// write into db
+	hold, err := s.store.CreateHold(r.Context(), userID, req.Order, sum, time.Now().Add(ttl))
+	if err != nil {
+		writeStorageError(w, r, "writing into db", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	enc := json.NewEncoder(w)
+	if err = enc.Encode(hold); err != nil {
+		logger.Log.Info("error encoding response", zap.String("error", err.Error()))
+		return
+	}
+}
+
+func (s *ChiServer) getHolds(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := getUserID(r)
+	if err != nil {
+		httperr.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	// reading from db
+	holds, err := s.store.GetUserHolds(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeStorageError(w, r, "reading from db", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err = enc.Encode(holds); err != nil {
+		logger.Log.Info("error encoding response", zap.String("error", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *ChiServer) captureHold(w http.ResponseWriter, r *http.Request) {
+	s.finishHold(w, r, func(userID, id int64) (any, error) {
+		return s.store.CaptureHold(r.Context(), userID, id)
+	})
+}
+
+func (s *ChiServer) releaseHold(w http.ResponseWriter, r *http.Request) {
+	s.finishHold(w, r, func(userID, id int64) (any, error) {
+		return s.store.ReleaseHold(r.Context(), userID, id)
+	})
+}
+
+func (s *ChiServer) finishHold(w http.ResponseWriter, r *http.Request, finish func(userID, id int64) (any, error)) {
+
+	userID, err := getUserID(r)
+	if err != nil {
+		httperr.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := urlParamID(r, "id")
+	if err != nil {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, err.Error())
+		return
+	}
+
+	// write into db
+	res, err := finish(userID, id)
+	if err != nil {
+		writeStorageError(w, r, "writing into db", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err = enc.Encode(res); err != nil {
+		logger.Log.Info("error encoding response", zap.String("error", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
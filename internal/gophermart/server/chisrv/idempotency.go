@@ -0,0 +1,133 @@
+package chisrv
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/zasuchilas/gophermart/internal/gophermart/config"
+	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/pkg/httperr"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+)
+
+const (
+	headerIdempotencyKey     = "Idempotency-Key"
+	headerIdempotentReplayed = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+	maxIdempotentBodySize   = 1 << 20
+)
+
+// idempotent makes the retries of a request with the same Idempotency-Key header safe:
+// the first response is stored for the user and the key and is replayed for the retries.
+// The key used with another request (method, path or body) is rejected.
+// The requests without the header are passed as is.
+func (s *ChiServer) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(headerIdempotencyKey)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, "the idempotency key is too long")
+			return
+		}
+
+		userID, err := getUserID(r)
+		if err != nil {
+			httperr.Status(w, r, http.StatusUnauthorized)
+			return
+		}
+
+		// the body is read here to fingerprint the request and is given to the handler again
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodySize))
+		if err != nil {
+			httperr.WriteError(w, r, httperr.ReadError(err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		stored, err := s.store.StartIdempotentRequest(r.Context(), userID, key,
+			requestHash(r, body), config.IdempotencyRetention)
+		if err != nil {
+			writeStorageError(w, r, "reading idempotency key from db", err)
+			return
+		}
+		if stored != nil {
+			if stored.ContentType != "" {
+				w.Header().Set("Content-Type", stored.ContentType)
+			}
+			w.Header().Set(headerIdempotentReplayed, "true")
+			w.WriteHeader(stored.StatusCode)
+			_, _ = w.Write(stored.Body)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		// the response is kept even if the client has gone, it is going to retry
+		ctx := context.WithoutCancel(r.Context())
+		if rec.statusCode() >= http.StatusInternalServerError {
+			if err = s.store.DeleteIdempotencyKey(ctx, userID, key); err != nil {
+				logger.Log.Info("deleting idempotency key from db", zap.String("error", err.Error()))
+			}
+			return
+		}
+		err = s.store.SaveIdempotentResponse(ctx, userID, key, &models.IdempotentResponse{
+			StatusCode:  rec.statusCode(),
+			ContentType: rec.contentType,
+			Body:        rec.body.Bytes(),
+		})
+		if err != nil {
+			logger.Log.Info("writing idempotent response into db", zap.String("error", err.Error()))
+		}
+	})
+}
+
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder passes the response to the client and keeps a copy of it.
+// The copy is the response of the handler: the recorder is inside amount.AsStrings,
+// so the replay is quoted again for the client asking for the string amounts.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	contentType string // before amount.AsStrings adds its parameter
+	body        bytes.Buffer
+}
+
+func (rec *responseRecorder) start(statusCode int) {
+	if rec.status == 0 {
+		rec.status = statusCode
+		rec.contentType = rec.Header().Get("Content-Type")
+	}
+}
+
+func (rec *responseRecorder) WriteHeader(statusCode int) {
+	rec.start(statusCode)
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.start(http.StatusOK)
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *responseRecorder) statusCode() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
@@ -0,0 +1,164 @@
+package chisrv
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/zasuchilas/gophermart/internal/gophermart/config"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"github.com/zasuchilas/gophermart/pkg/amount"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type idempotencyEntry struct {
+	hash string
+	resp *models.IdempotentResponse
+}
+
+// idempotencyStore keeps the idempotency keys in memory, the other methods are not used
+type idempotencyStore struct {
+	storage.Storage
+	mu   sync.Mutex
+	keys map[string]*idempotencyEntry
+}
+
+func (m *idempotencyStore) StartIdempotentRequest(_ context.Context, _ int64, key, requestHash string, _ time.Duration) (*models.IdempotentResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.keys[key]
+	if !ok {
+		m.keys[key] = &idempotencyEntry{hash: requestHash}
+		return nil, nil
+	}
+	if e.hash != requestHash {
+		return nil, storage.ErrIdempotencyKeyReused
+	}
+	if e.resp == nil {
+		return nil, storage.ErrIdempotencyKeyInProgress
+	}
+	return e.resp, nil
+}
+
+func (m *idempotencyStore) SaveIdempotentResponse(_ context.Context, _ int64, key string, resp *models.IdempotentResponse) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[key].resp = resp
+	return nil
+}
+
+func (m *idempotencyStore) DeleteIdempotencyKey(_ context.Context, _ int64, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.keys, key)
+	return nil
+}
+
+func TestIdempotent(t *testing.T) {
+	config.SecretKey = "testsecretkey"
+	config.AccessTokenTTL = time.Minute
+	InitJWT()
+
+	s := &ChiServer{store: &idempotencyStore{keys: make(map[string]*idempotencyEntry)}}
+	var calls int
+	status := http.StatusOK
+	r := chi.NewRouter()
+	r.Use(verifier)
+	r.With(s.idempotent).Post("/withdraw", func(w http.ResponseWriter, _ *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte("done"))
+	})
+	token := makeToken(1, 1, models.RoleUser)
+
+	do := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/withdraw", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		if key != "" {
+			req.Header.Set(headerIdempotencyKey, key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name     string
+		key      string
+		body     string
+		status   int
+		wantCode int
+		replayed bool
+		calls    int
+	}{
+		{name: "first request", key: "k1", body: `{"sum":1}`, status: http.StatusOK, wantCode: http.StatusOK, calls: 1},
+		{name: "retry is replayed", key: "k1", body: `{"sum":1}`, status: http.StatusPaymentRequired, wantCode: http.StatusOK, replayed: true, calls: 1},
+		{name: "another body", key: "k1", body: `{"sum":2}`, wantCode: http.StatusUnprocessableEntity, calls: 1},
+		{name: "without key", body: `{"sum":1}`, status: http.StatusOK, wantCode: http.StatusOK, calls: 2},
+		{name: "failed request", key: "k2", body: `{"sum":1}`, status: http.StatusInternalServerError, wantCode: http.StatusInternalServerError, calls: 3},
+		{name: "failed request is retried", key: "k2", body: `{"sum":1}`, status: http.StatusOK, wantCode: http.StatusOK, calls: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status = tt.status
+			w := do(tt.key, tt.body)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if replayed := w.Header().Get(headerIdempotentReplayed) == "true"; replayed != tt.replayed {
+				t.Errorf("replayed = %v, want %v", replayed, tt.replayed)
+			}
+			if tt.replayed && (w.Body.String() != "done" || w.Header().Get("Content-Type") != "text/plain") {
+				t.Errorf("replayed response = %q (%s)", w.Body.String(), w.Header().Get("Content-Type"))
+			}
+			if calls != tt.calls {
+				t.Errorf("handler calls = %d, want %d", calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestIdempotentAmountsAsStrings(t *testing.T) {
+	config.SecretKey = "testsecretkey"
+	config.AccessTokenTTL = time.Minute
+	InitJWT()
+
+	s := &ChiServer{store: &idempotencyStore{keys: make(map[string]*idempotencyEntry)}}
+	r := chi.NewRouter()
+	r.Use(amount.AsStrings)
+	r.Use(verifier)
+	r.With(s.idempotent).Post("/withdraw", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"sum":1.50}`))
+	})
+	token := makeToken(1, 1, models.RoleUser)
+
+	tests := []struct {
+		name        string
+		accept      string
+		contentType string
+		body        string
+	}{
+		{"first request with strings", "application/json; amounts=string", "application/json; amounts=string", `{"sum":"1.50"}`},
+		{"replay with numbers", "application/json", "application/json", `{"sum":1.50}`},
+		{"replay with strings", "application/json; amounts=string", "application/json; amounts=string", `{"sum":"1.50"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/withdraw", strings.NewReader(`{"sum":1.5}`))
+			req.Header.Set("Authorization", "Bearer "+token)
+			req.Header.Set(headerIdempotencyKey, "k1")
+			req.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Body.String() != tt.body || w.Header().Get("Content-Type") != tt.contentType {
+				t.Errorf("response = %s (%s), want %s (%s)", w.Body.String(), w.Header().Get("Content-Type"), tt.body, tt.contentType)
+			}
+		})
+	}
+}
@@ -3,27 +3,121 @@ package chisrv
 import (
 	"errors"
 	"github.com/go-chi/jwtauth/v5"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
 	"github.com/zasuchilas/gophermart/internal/gophermart/config"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
 	"github.com/zasuchilas/gophermart/pkg/converters"
+	"github.com/zasuchilas/gophermart/pkg/httperr"
 	"net/http"
 	"time"
 )
 
-var tokenAuth *jwtauth.JWTAuth
+// The tokens are signed with the active key and carry its kid,
+// the tokens of the other configured keys are still accepted, so the keys can be rotated
+// without logging everyone out.
 
-func InitJWT() {
-	tokenAuth = jwtauth.New("HS256", []byte(config.SecretKey), nil /*jwt.WithAcceptableSkew(time.Hour)*/)
+var (
+	tokenAuth  *jwtauth.JWTAuth // signs with the active key
+	verifyKeys jwk.Set          // all the configured keys
+	publicKeys jwk.Set          // the public parts of the asymmetric keys for /.well-known/jwks.json
+)
+
+func InitJWT() error {
+	keys, err := loadKeys()
+	if err != nil {
+		return err
+	}
+
+	activeKID := config.JWTActiveKID
+	if activeKID == "" {
+		activeKID = keys[0].KeyID()
+	}
+
+	verifyKeys, publicKeys = jwk.NewSet(), jwk.NewSet()
+	var active jwk.Key
+	for _, key := range keys {
+		if key.KeyID() == activeKID {
+			active = key
+		}
+		if err = verifyKeys.AddKey(key); err != nil {
+			return err
+		}
+		if key.KeyType() == jwa.OctetSeq {
+			// the shared secrets are never published
+			continue
+		}
+		pub, er := key.PublicKey()
+		if er != nil {
+			return er
+		}
+		if err = publicKeys.AddKey(pub); err != nil {
+			return err
+		}
+	}
+	if active == nil {
+		return errors.New("the active jwt key " + activeKID + " is not configured")
+	}
+	if !canSign(active) {
+		return errors.New("the active jwt key " + activeKID + " is a public key")
+	}
+
+	tokenAuth = jwtauth.New(active.Algorithm().String(), active, nil /*jwt.WithAcceptableSkew(time.Hour)*/)
+	return nil
 }
 
-func makeToken(userID int64) string {
+// makeToken issues a short-lived access token of the session
+func makeToken(userID, sessionID int64, role string) string {
 	_, tokenString, _ := tokenAuth.Encode(map[string]interface{}{
 		"userID": userID,
-		"exp":    time.Now().Add(100 * 24 * time.Hour).Unix(),
+		"sid":    sessionID,
+		"role":   role,
+		"exp":    time.Now().Add(config.AccessTokenTTL).Unix(),
 	})
 	return tokenString
 }
 
+// verifier is jwtauth.Verifier choosing the verification key by the kid of the token
+func verifier(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := verifyRequest(r)
+		ctx := jwtauth.NewContext(r.Context(), token, err)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authenticator is jwtauth.Authenticator responding with the error envelope
+func authenticator(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, _, err := jwtauth.FromContext(r.Context()); err != nil || token == nil {
+			httperr.Write(w, r, http.StatusUnauthorized, httperr.CodeUnauthorized, "the access token is missing, invalid or expired")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func verifyRequest(r *http.Request) (jwt.Token, error) {
+	tokenString := jwtauth.TokenFromHeader(r)
+	if cookie, err := r.Cookie(jwtCookieName); tokenString == "" && err == nil {
+		tokenString = cookie.Value
+	}
+	if tokenString == "" {
+		return nil, jwtauth.ErrNoTokenFound
+	}
+
+	token, err := jwt.Parse([]byte(tokenString), jwt.WithKeySet(verifyKeys), jwt.WithValidate(true))
+	if err != nil {
+		return token, jwtauth.ErrorReason(err)
+	}
+	return token, nil
+}
+
 func getUserID(r *http.Request) (int64, error) {
+	if key := apiKeyFromContext(r.Context()); key != nil {
+		return key.UserID, nil
+	}
 	_, claims, err := jwtauth.FromContext(r.Context())
 	if err != nil {
 		return 0, err
@@ -34,3 +128,32 @@ func getUserID(r *http.Request) (int64, error) {
 	}
 	return converters.InterfaceToInt64(userID)
 }
+
+// getRole returns the role of the token, the tokens issued before the roles appeared and the API keys are of users
+func getRole(r *http.Request) string {
+	if apiKeyFromContext(r.Context()) != nil {
+		// the keys never act as the operators
+		return models.RoleUser
+	}
+	_, claims, err := jwtauth.FromContext(r.Context())
+	if err != nil {
+		return ""
+	}
+	role, ok := claims["role"].(string)
+	if !ok {
+		return models.RoleUser
+	}
+	return role
+}
+
+func getSessionID(r *http.Request) (int64, error) {
+	_, claims, err := jwtauth.FromContext(r.Context())
+	if err != nil {
+		return 0, err
+	}
+	sessionID, ok := claims["sid"]
+	if !ok {
+		return 0, errors.New("sid not found in token")
+	}
+	return converters.InterfaceToInt64(sessionID)
+}
@@ -0,0 +1,124 @@
+package chisrv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/zasuchilas/gophermart/internal/gophermart/config"
+	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
+	"go.uber.org/zap"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// hmacKeyPrefix marks a shared secret in config.JWTKeys instead of a PEM file path
+const hmacKeyPrefix = "hs256:"
+
+// loadKeys returns the keys of config.JWTKeys (kid=source, one per line, so a secret may contain any other character)
+// or the HS256 key of config.SecretKey if the list is empty.
+func loadKeys() ([]jwk.Key, error) {
+	specs := config.JWTKeys
+	if strings.TrimSpace(specs) == "" {
+		specs = "default=" + hmacKeyPrefix + config.SecretKey
+	}
+
+	keys := make([]jwk.Key, 0)
+	seen := make(map[string]bool)
+	for _, spec := range strings.Split(specs, "\n") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		kid, source, ok := strings.Cut(spec, "=")
+		if !ok || kid == "" || source == "" {
+			return nil, fmt.Errorf("jwt key %q is not kid=source", spec)
+		}
+		if seen[kid] {
+			return nil, fmt.Errorf("jwt key %s is configured twice", kid)
+		}
+		seen[kid] = true
+
+		key, err := parseKey(kid, source)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %s: %w", kid, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// parseKey reads hs256:secret or a PEM file of an RSA (RS256) or Ed25519 (EdDSA) key,
+// the file with a public key is accepted only for verification
+func parseKey(kid, source string) (jwk.Key, error) {
+	var (
+		key jwk.Key
+		alg jwa.SignatureAlgorithm
+		err error
+	)
+	if secret, ok := strings.CutPrefix(source, hmacKeyPrefix); ok {
+		if config.EnvType == "production" && secret == config.DefaultSecretKey {
+			return nil, errors.New("the default secret key cannot be used in production")
+		}
+		key, err = jwk.FromRaw([]byte(secret))
+		if err != nil {
+			return nil, err
+		}
+		alg = jwa.HS256
+	} else {
+		data, er := os.ReadFile(source)
+		if er != nil {
+			return nil, er
+		}
+		key, err = jwk.ParseKey(data, jwk.WithPEM(true))
+		if err != nil {
+			return nil, err
+		}
+		switch k := key.(type) {
+		case jwk.RSAPrivateKey, jwk.RSAPublicKey:
+			alg = jwa.RS256
+		case jwk.OKPPrivateKey:
+			if k.Crv() != jwa.Ed25519 {
+				return nil, fmt.Errorf("unsupported curve %s", k.Crv())
+			}
+			alg = jwa.EdDSA
+		case jwk.OKPPublicKey:
+			if k.Crv() != jwa.Ed25519 {
+				return nil, fmt.Errorf("unsupported curve %s", k.Crv())
+			}
+			alg = jwa.EdDSA
+		default:
+			return nil, fmt.Errorf("unsupported key type %s", key.KeyType())
+		}
+	}
+
+	if err = key.Set(jwk.KeyIDKey, kid); err != nil {
+		return nil, err
+	}
+	if err = key.Set(jwk.AlgorithmKey, alg); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func canSign(key jwk.Key) bool {
+	switch key.(type) {
+	case jwk.SymmetricKey, jwk.RSAPrivateKey, jwk.OKPPrivateKey:
+		return true
+	}
+	return false
+}
+
+// jwks publishes the public keys for the other services verifying our tokens
+func (s *ChiServer) jwks(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(publicKeys); err != nil {
+		logger.Log.Info("error encoding response", zap.String("error", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,97 @@
+package chisrv
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/zasuchilas/gophermart/internal/gophermart/config"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writePrivateKey(t *testing.T, name string, key any) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshaling key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatalf("writing key: %v", err)
+	}
+	return path
+}
+
+func TestKeyRotation(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating rsa key: %v", err)
+	}
+	config.EnvType = "develop"
+	config.AccessTokenTTL = time.Minute
+	// the secret contains the characters of the kid=source syntax
+	config.JWTKeys = "old=hs256:old,secret=key\nrsa=" + writePrivateKey(t, "rsa.pem", rsaKey) +
+		"\ned=" + writePrivateKey(t, "ed.pem", edKey) + "\n"
+	t.Cleanup(func() { config.JWTKeys, config.JWTActiveKID = "", "" })
+
+	// the tokens are issued with every key in turn and checked after the rotation to the last one
+	tokens := make(map[string]string)
+	for _, kid := range []string{"old", "rsa", "ed"} {
+		config.JWTActiveKID = kid
+		if err = InitJWT(); err != nil {
+			t.Fatalf("init jwt with %s: %v", kid, err)
+		}
+		tokens[kid] = makeToken(1, 1, models.RoleUser)
+	}
+	for kid, token := range tokens {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if _, err = verifyRequest(req); err != nil {
+			t.Errorf("verifying token of %s: %v", kid, err)
+		}
+	}
+
+	// the shared secret is not published
+	w := httptest.NewRecorder()
+	(&ChiServer{}).jwks(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			D   string `json:"d"`
+		} `json:"keys"`
+	}
+	if err = json.Unmarshal(w.Body.Bytes(), &set); err != nil {
+		t.Fatalf("decoding jwks: %v", err)
+	}
+	published := make(map[string]string)
+	for _, k := range set.Keys {
+		if k.D != "" {
+			t.Errorf("private part of %s is published", k.Kid)
+		}
+		published[k.Kid] = k.Alg
+	}
+	if len(published) != 2 || published["rsa"] != "RS256" || published["ed"] != "EdDSA" {
+		t.Errorf("published keys = %v, want rsa RS256 and ed EdDSA", published)
+	}
+}
+
+func TestDefaultSecretInProduction(t *testing.T) {
+	config.EnvType = "production"
+	config.SecretKey = config.DefaultSecretKey
+	t.Cleanup(func() { config.EnvType, config.SecretKey = "", "testsecretkey" })
+
+	if err := InitJWT(); err == nil {
+		t.Error("the default secret is accepted in production")
+	}
+}
@@ -0,0 +1,91 @@
+package chisrv
+
+import (
+	"errors"
+	"fmt"
+	"github.com/zasuchilas/gophermart/internal/common"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxListLimit = 1000
+
+// the order statuses stored by the worker, REGISTERED comes as is from the accrual service
+var orderStatuses = []string{
+	common.OrderStatusNew,
+	common.OrderStatusRegistered,
+	common.OrderStatusProcessing,
+	common.OrderStatusInvalid,
+	common.OrderStatusProcessed,
+}
+
+// parseListQuery reads the pagination and filter parameters of a list request:
+// limit, cursor, status (comma separated), from, to (RFC3339) and sort (asc or desc).
+// Without the limit the whole list is returned.
+func parseListQuery(r *http.Request, statuses []string) (*models.ListQuery, error) {
+	var (
+		q      models.ListQuery
+		err    error
+		values = r.URL.Query()
+	)
+
+	if v := values.Get("limit"); v != "" {
+		q.Limit, err = strconv.Atoi(v)
+		if err != nil || q.Limit < 1 || q.Limit > maxListLimit {
+			return nil, fmt.Errorf("the limit must be a number from 1 to %d", maxListLimit)
+		}
+	}
+
+	if v := values.Get("cursor"); v != "" {
+		q.Cursor, err = models.ParseCursor(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if v := values.Get("status"); v != "" {
+		if statuses == nil {
+			return nil, errors.New("the list cannot be filtered by status")
+		}
+		for _, status := range strings.Split(v, ",") {
+			status = strings.ToUpper(strings.TrimSpace(status))
+			if !slices.Contains(statuses, status) {
+				return nil, fmt.Errorf("unknown status %q", status)
+			}
+			q.Statuses = append(q.Statuses, status)
+		}
+	}
+
+	if v := values.Get("from"); v != "" {
+		q.From, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, errors.New("the from must be a RFC3339 time")
+		}
+	}
+	if v := values.Get("to"); v != "" {
+		q.To, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, errors.New("the to must be a RFC3339 time")
+		}
+	}
+
+	switch values.Get("sort") {
+	case "", "desc":
+	case "asc":
+		q.Asc = true
+	default:
+		return nil, errors.New("the sort must be asc or desc")
+	}
+
+	return &q, nil
+}
+
+func setNextCursor(w http.ResponseWriter, next string) {
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+	}
+}
@@ -0,0 +1,50 @@
+package chisrv
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseListQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		wantErr bool
+	}{
+		{name: "defaults", query: ""},
+		{name: "full", query: "limit=10&status=processed,NEW&from=2024-01-01T00:00:00Z&to=2024-02-01T00:00:00Z&sort=asc"},
+		{name: "zero limit", query: "limit=0", wantErr: true},
+		{name: "too big limit", query: "limit=1001", wantErr: true},
+		{name: "bad cursor", query: "cursor=%21%21", wantErr: true},
+		{name: "registered status", query: "status=REGISTERED,processing"},
+		{name: "unknown status", query: "status=DONE", wantErr: true},
+		{name: "bad time", query: "from=yesterday", wantErr: true},
+		{name: "bad sort", query: "sort=up", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/user/orders?"+tt.query, nil)
+			_, err := parseListQuery(r, orderStatuses)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseListQuery() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	r := httptest.NewRequest("GET", "/api/user/orders?limit=10&status=processed,NEW&from=2024-01-01T00:00:00Z&sort=asc", nil)
+	q, err := parseListQuery(r, orderStatuses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Limit != 10 || !q.Asc || len(q.Statuses) != 2 || q.Statuses[0] != "PROCESSED" ||
+		!q.From.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) || !q.To.IsZero() {
+		t.Errorf("unexpected query: %+v", q)
+	}
+
+	r = httptest.NewRequest("GET", "/api/user/withdrawals?status=NEW", nil)
+	if _, err = parseListQuery(r, nil); err == nil {
+		t.Error("status filter is accepted for a list without statuses")
+	}
+}
@@ -0,0 +1,24 @@
+package chisrv
+
+import (
+	_ "embed"
+	"github.com/zasuchilas/gophermart/internal/gophermart/config"
+	"github.com/zasuchilas/gophermart/pkg/openapi"
+	"net/http"
+)
+
+// openapi.json documents the routes of the router, TestRoutesMatchSpec keeps them in sync
+
+//go:embed openapi.json
+var openapiDocument []byte
+
+var apiSpec = openapi.MustParse(openapiDocument)
+
+// validateRequests is apiSpec.Validate with -openapi-validation, it goes after the authentication,
+// so the unauthenticated requests get 401 and learn nothing about the request schemas
+func validateRequests(next http.Handler) http.Handler {
+	if !config.OpenAPIValidation {
+		return next
+	}
+	return apiSpec.Validate(next)
+}
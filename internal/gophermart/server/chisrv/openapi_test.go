@@ -0,0 +1,114 @@
+package chisrv
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/zasuchilas/gophermart/internal/gophermart/config"
+	"github.com/zasuchilas/gophermart/pkg/httperr"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRoutesMatchSpec(t *testing.T) {
+	s := &ChiServer{}
+	var routed []string
+	err := chi.Walk(s.router(), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		routed = append(routed, method+" "+route)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walking the router: %v", err)
+	}
+	documented := apiSpec.Routes()
+
+	for _, route := range routed {
+		if !slices.Contains(documented, route) {
+			t.Errorf("%s is routed but not documented in openapi.json", route)
+		}
+	}
+	for _, route := range documented {
+		if !slices.Contains(routed, route) {
+			t.Errorf("%s is documented in openapi.json but not routed", route)
+		}
+	}
+}
+
+func TestServeSpec(t *testing.T) {
+	s := &ChiServer{}
+	w := httptest.NewRecorder()
+	s.router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if !strings.Contains(w.Body.String(), `"openapi": "3.0.3"`) {
+		t.Errorf("the body is not the openapi document: %.100s", w.Body.String())
+	}
+}
+
+func TestSpecValidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        string
+		wantErr     bool
+	}{
+		{"register", http.MethodPost, "/api/user/register", "application/json", `{"login": "gopher", "password": "secret"}`, false},
+		{"register short login", http.MethodPost, "/api/user/register", "application/json", `{"login": "go", "password": "secret"}`, true},
+		{"withdraw", http.MethodPost, "/api/user/balance/withdraw", "application/json", `{"order": "2377225624", "sum": "751.50"}`, false},
+		{"withdraw unknown field", http.MethodPost, "/api/user/balance/withdraw", "application/json", `{"order": "2377225624", "sum": 751, "comment": "x"}`, true},
+		{"upload order", http.MethodPost, "/api/user/orders", "text/plain", "12345678903", false},
+		{"batch json", http.MethodPost, "/api/user/orders/batch", "application/json", `["12345678903", 9278923470]`, false},
+		{"refresh without body", http.MethodPost, "/api/user/token/refresh", "", "", false},
+		{"api key scope", http.MethodPost, "/api/user/api-keys", "application/json", `{"name": "pos", "scopes": ["admin"]}`, true},
+		{"list", http.MethodGet, "/api/user/orders?limit=10&status=NEW&sort=asc", "", "", false},
+		{"list limit", http.MethodGet, "/api/user/orders?limit=0", "", "", true},
+		{"hold id", http.MethodPost, "/api/user/holds/0/release", "", "", true},
+		{"admin reason", http.MethodPost, "/api/admin/users/7/restore", "application/json", `{"reason": ""}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if err := apiSpec.ValidateRequest(req); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidationAfterAuthentication(t *testing.T) {
+	config.OpenAPIValidation = true
+	defer func() { config.OpenAPIValidation = false }()
+	config.MaxBodySize = httperr.DefaultMaxBodySize
+	s := &ChiServer{}
+	router := s.router()
+
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		status int
+	}{
+		{"unauthenticated invalid body", "/api/user/balance/withdraw", `{"order": "2377225624", "sum": 751, "comment": "x"}`, http.StatusUnauthorized},
+		{"unauthenticated admin invalid body", "/api/admin/users/7/restore", `{"reason": ""}`, http.StatusUnauthorized},
+		{"public invalid body", "/api/user/register", `{"login": "go", "password": "secret"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package chisrv
+
+import (
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/pkg/httperr"
+	"net/http"
+	"slices"
+)
+
+// hasRole reports whether the role has the rights of the required one
+func hasRole(role, required string) bool {
+	rank := slices.Index(models.Roles, role)
+	return rank >= 0 && rank >= slices.Index(models.Roles, required)
+}
+
+// requireRole responds with 403 to the tokens without the required role,
+// it goes after activeSession which checks the role of the token is current
+func requireRole(required string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !hasRole(getRole(r), required) {
+				httperr.Write(w, r, http.StatusForbidden, codeRoleRequired, "the route requires the "+required+" role")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package chisrv
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/zasuchilas/gophermart/internal/gophermart/config"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequireRole(t *testing.T) {
+	config.SecretKey = "testsecretkey"
+	config.AccessTokenTTL = time.Minute
+	InitJWT()
+
+	s := &ChiServer{store: &sessionStore{roles: map[int64]string{
+		2: models.RoleSupport,
+		3: models.RoleAdmin,
+	}}}
+	ok := func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	r := chi.NewRouter()
+	r.Use(verifier)
+	r.Use(authenticator)
+	r.Use(s.activeSession)
+	r.With(requireRole(models.RoleSupport)).Get("/view", ok)
+	r.With(requireRole(models.RoleAdmin)).Post("/change", ok)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{"user views", http.MethodGet, "/view", makeToken(1, 1, models.RoleUser), http.StatusForbidden},
+		{"support views", http.MethodGet, "/view", makeToken(2, 2, models.RoleSupport), http.StatusOK},
+		{"support changes", http.MethodPost, "/change", makeToken(2, 2, models.RoleSupport), http.StatusForbidden},
+		{"admin views", http.MethodGet, "/view", makeToken(3, 3, models.RoleAdmin), http.StatusOK},
+		{"admin changes", http.MethodPost, "/change", makeToken(3, 3, models.RoleAdmin), http.StatusOK},
+		{"user with forged role", http.MethodPost, "/change", makeToken(1, 1, models.RoleAdmin), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestHasRole(t *testing.T) {
+	if hasRole("", models.RoleUser) || hasRole("root", models.RoleUser) {
+		t.Error("an unknown role has rights")
+	}
+	if !hasRole(models.RoleAdmin, models.RoleSupport) || hasRole(models.RoleSupport, models.RoleAdmin) {
+		t.Error("the roles are not ordered")
+	}
+}
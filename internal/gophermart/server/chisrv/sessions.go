@@ -0,0 +1,235 @@
+package chisrv
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/zasuchilas/gophermart/internal/gophermart/config"
+	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"github.com/zasuchilas/gophermart/pkg/httperr"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The access token lives config.AccessTokenTTL, it is sent in the "jwt" cookie for browsers
+// and in the Authorization header and the response body for API clients.
+// The refresh token renews it and is replaced on every use.
+
+const (
+	jwtCookieName     = "jwt" // read by verifyRequest
+	refreshCookieName = "refresh_token"
+	refreshCookiePath = "/api/user/token"
+)
+
+func newRefreshToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// startSession creates a new session of the user and responds with its tokens
+func (s *ChiServer) startSession(w http.ResponseWriter, r *http.Request, userID int64, role string) error {
+	refreshToken, refreshHash, err := newRefreshToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(config.RefreshTokenTTL)
+	sessionID, err := s.store.CreateSession(r.Context(), userID, refreshHash, expiresAt)
+	if err != nil {
+		return err
+	}
+	return writeTokens(w, userID, sessionID, role, refreshToken, expiresAt)
+}
+
+func writeTokens(w http.ResponseWriter, userID, sessionID int64, role, refreshToken string, expiresAt time.Time) error {
+	csrfToken, err := newCSRFToken()
+	if err != nil {
+		return err
+	}
+	tokens := &models.TokenResponse{
+		AccessToken:  makeToken(userID, sessionID, role),
+		TokenType:    "Bearer",
+		ExpiresIn:    int(config.AccessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+	}
+
+	http.SetCookie(w, newCookie(jwtCookieName, tokens.AccessToken, "/", time.Now().Add(config.AccessTokenTTL), true))
+	http.SetCookie(w, newCookie(refreshCookieName, refreshToken, refreshCookiePath, expiresAt, true))
+	http.SetCookie(w, newCookie(csrfCookieName, csrfToken, "/", expiresAt, false)) // read by the frontend
+	w.Header().Set("Authorization", tokens.TokenType+" "+tokens.AccessToken)
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err = enc.Encode(tokens); err != nil {
+		logger.Log.Info("error encoding response", zap.String("error", err.Error()))
+	}
+	return nil
+}
+
+func newCookie(name, value, path string, expires time.Time, httpOnly bool) *http.Cookie {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   config.CookieDomain,
+		Expires:  expires,
+		Secure:   config.CookieSecure,
+		HttpOnly: httpOnly,
+		SameSite: http.SameSiteLaxMode,
+	}
+	switch strings.ToLower(config.CookieSameSite) {
+	case "strict":
+		c.SameSite = http.SameSiteStrictMode
+	case "none":
+		c.SameSite = http.SameSiteNoneMode
+	}
+	if expires.IsZero() {
+		c.MaxAge = -1
+	}
+	return c
+}
+
+func clearAuthCookies(w http.ResponseWriter) {
+	http.SetCookie(w, newCookie(jwtCookieName, "", "/", time.Time{}, true))
+	http.SetCookie(w, newCookie(refreshCookieName, "", refreshCookiePath, time.Time{}, true))
+	http.SetCookie(w, newCookie(csrfCookieName, "", "/", time.Time{}, false))
+}
+
+func (s *ChiServer) refreshToken(w http.ResponseWriter, r *http.Request) {
+
+	// the cookie of browsers or the body of API clients,
+	// the cookie is sent by browsers on their own, so it is checked like the cookie of the access token
+	var token string
+	if cookie, err := r.Cookie(refreshCookieName); err == nil {
+		if config.CSRFProtection && !csrfTokenMatches(r) {
+			writeCSRFMismatch(w, r)
+			return
+		}
+		token = cookie.Value
+	} else {
+		var req models.RefreshRequest
+		if err = httperr.DecodeOptional(r, &req); err != nil {
+			httperr.WriteError(w, r, err)
+			return
+		}
+		token = req.RefreshToken
+	}
+	if token == "" {
+		httperr.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	refreshToken, refreshHash, err := newRefreshToken()
+	if err != nil {
+		httperr.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+	expiresAt := time.Now().Add(config.RefreshTokenTTL)
+
+	// rotating the refresh token
+	session, err := s.store.RefreshSession(r.Context(), hashToken(token), refreshHash, expiresAt)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrSessionRevoked) || errors.Is(err, storage.ErrGone) {
+			clearAuthCookies(w)
+			httperr.Status(w, r, http.StatusUnauthorized)
+			return
+		}
+		writeStorageError(w, r, "refreshing session", err)
+		return
+	}
+
+	if err = writeTokens(w, session.UserID, session.ID, session.Role, refreshToken, expiresAt); err != nil {
+		httperr.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *ChiServer) logout(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := getUserID(r)
+	if err != nil {
+		httperr.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+	sessionID, err := getSessionID(r)
+	if err != nil {
+		httperr.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	err = s.store.RevokeSession(r.Context(), userID, sessionID)
+	if err != nil {
+		writeStorageError(w, r, "revoking session", err)
+		return
+	}
+
+	clearAuthCookies(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+// logoutEverywhere revokes all the sessions of the user including the current one
+func (s *ChiServer) logoutEverywhere(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := getUserID(r)
+	if err != nil {
+		httperr.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	_, err = s.store.RevokeUserSessions(r.Context(), userID)
+	if err != nil {
+		writeStorageError(w, r, "revoking sessions", err)
+		return
+	}
+
+	clearAuthCookies(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+// activeSession rejects the access tokens of revoked sessions and deleted users
+// and the tokens with a changed role (the client refreshes them), it goes after authenticator
+func (s *ChiServer) activeSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := getUserID(r)
+		if err != nil {
+			httperr.Status(w, r, http.StatusUnauthorized)
+			return
+		}
+		sessionID, err := getSessionID(r)
+		if err != nil {
+			// the tokens issued before the sessions appeared
+			httperr.Status(w, r, http.StatusUnauthorized)
+			return
+		}
+
+		role, err := s.store.CheckSession(r.Context(), userID, sessionID)
+		if err != nil {
+			if errors.Is(err, storage.ErrGone) {
+				httperr.Write(w, r, http.StatusUnauthorized, httperr.CodeUnauthorized, "the user is deleted")
+				return
+			}
+			writeStorageError(w, r, "checking session", err)
+			return
+		}
+		if role != getRole(r) {
+			httperr.Write(w, r, http.StatusUnauthorized, codeRoleChanged, "the role is changed, refresh the access token")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
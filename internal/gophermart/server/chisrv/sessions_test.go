@@ -0,0 +1,80 @@
+package chisrv
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/zasuchilas/gophermart/internal/gophermart/config"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// sessionStore knows the revoked sessions, the deleted users and the roles, the other methods are not used
+type sessionStore struct {
+	storage.Storage
+	revoked map[int64]bool
+	deleted map[int64]bool
+	roles   map[int64]string
+}
+
+func (m *sessionStore) CheckSession(_ context.Context, userID, sessionID int64) (string, error) {
+	if m.deleted[userID] {
+		return "", storage.ErrGone
+	}
+	if m.revoked[sessionID] {
+		return "", storage.ErrSessionRevoked
+	}
+	if role, ok := m.roles[userID]; ok {
+		return role, nil
+	}
+	return models.RoleUser, nil
+}
+
+func TestActiveSession(t *testing.T) {
+	config.SecretKey = "testsecretkey"
+	config.AccessTokenTTL = time.Minute
+	InitJWT()
+
+	s := &ChiServer{store: &sessionStore{
+		revoked: map[int64]bool{2: true},
+		deleted: map[int64]bool{3: true},
+	}}
+	r := chi.NewRouter()
+	r.Use(verifier)
+	r.Use(authenticator)
+	r.Use(s.activeSession)
+	r.Get("/balance", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	_, withoutSession, _ := tokenAuth.Encode(map[string]interface{}{
+		"userID": 1,
+		"exp":    time.Now().Add(time.Minute).Unix(),
+	})
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"active session", makeToken(1, 1, models.RoleUser), http.StatusOK},
+		{"revoked session", makeToken(1, 2, models.RoleUser), http.StatusUnauthorized},
+		{"deleted user", makeToken(3, 4, models.RoleUser), http.StatusUnauthorized},
+		{"changed role", makeToken(1, 1, models.RoleAdmin), http.StatusUnauthorized},
+		{"token without session", withoutSession, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/balance", nil)
+			req.AddCookie(&http.Cookie{Name: jwtCookieName, Value: tt.token})
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package chisrv
+
+import (
+	"github.com/zasuchilas/gophermart/internal/gophermart/config"
+	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"github.com/zasuchilas/gophermart/pkg/httperr"
+	"go.uber.org/zap"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The failed logins are counted per login and per client address. After a few failures
+// the next attempt of the login must wait a delay doubling with every failure, after config.LoginMaxFailures
+// the login is locked for config.LoginLockout. The client address is shared by the users of a NAT or an office,
+// so it is not delayed and is locked only after config.LoginIPMaxFailures.
+// The attempt is counted before the password hash is checked and forgiven when it succeeds,
+// the locked attempts get 429 without the check.
+
+const loginFreeFailures = 3 // the failures without a delay
+
+// clientIP is the address of the connection, the proxy headers can be forged and are not used
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func loginThrottling() bool {
+	return config.LoginMaxFailures > 0
+}
+
+// loginDelay is the time the login is locked for after the failures
+func loginDelay(failures, maxFailures int) time.Duration {
+	if failures >= maxFailures {
+		return config.LoginLockout
+	}
+	if failures < loginFreeFailures {
+		return 0
+	}
+	d := config.LoginDelay << (failures - loginFreeFailures)
+	if d <= 0 || d > config.LoginLockout {
+		return config.LoginLockout
+	}
+	return d
+}
+
+// loginDelays is the lock schedule of the login key, delays[n] is the lock after n failures
+func loginDelays() []time.Duration {
+	delays := make([]time.Duration, config.LoginMaxFailures+1)
+	for i := range delays {
+		delays[i] = loginDelay(i, config.LoginMaxFailures)
+	}
+	return delays
+}
+
+// ipDelays is the lock schedule of the client address key, only the lockout after its max failures
+func ipDelays() []time.Duration {
+	delays := make([]time.Duration, config.LoginIPMaxFailures+1)
+	delays[config.LoginIPMaxFailures] = config.LoginLockout
+	return delays
+}
+
+type loginKey struct {
+	key    string
+	delays []time.Duration
+}
+
+// loginKeys are the counter keys of the attempt, the address first, so a locked address does not count the login
+func loginKeys(r *http.Request, login string) []loginKey {
+	keys := make([]loginKey, 0, 2)
+	if config.LoginIPMaxFailures > 0 {
+		keys = append(keys, loginKey{storage.IPKey(clientIP(r)), ipDelays()})
+	}
+	return append(keys, loginKey{storage.LoginKey(login), loginDelays()})
+}
+
+// loginLocked counts the attempt of the login and the client address, a locked one is responded with 429 and Retry-After
+func (s *ChiServer) loginLocked(w http.ResponseWriter, r *http.Request, login string) bool {
+	if !loginThrottling() {
+		return false
+	}
+
+	ctx := r.Context()
+	counted := make([]loginKey, 0, 2)
+	for _, k := range loginKeys(r, login) {
+		attempt, err := s.store.AddLoginAttempt(ctx, k.key, config.LoginLockout, k.delays)
+		if err != nil {
+			// the login is not blocked by a database failure here, the next step fails anyway
+			logger.Log.Info("counting login attempt", zap.String("key", k.key), zap.String("error", err.Error()))
+			continue
+		}
+		if !attempt.Rejected {
+			if attempt.Failures >= len(k.delays)-1 {
+				logger.Log.Warn("login locked", zap.String("key", k.key), zap.Int("failures", attempt.Failures))
+			}
+			counted = append(counted, k)
+			continue
+		}
+
+		// the keys counted before are not to blame
+		for _, c := range counted {
+			s.forgiveLoginAttempt(r, c)
+		}
+		retryAfter := time.Until(attempt.LockedUntil)
+		w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(retryAfter.Seconds())), 1)))
+		httperr.Write(w, r, http.StatusTooManyRequests, codeLoginLocked, "too many failed logins")
+		return true
+	}
+	return false
+}
+
+func (s *ChiServer) forgiveLoginAttempt(r *http.Request, k loginKey) {
+	if err := s.store.ForgiveLoginAttempt(r.Context(), k.key, k.delays); err != nil {
+		logger.Log.Info("forgiving login attempt", zap.String("key", k.key), zap.String("error", err.Error()))
+	}
+}
+
+// loginSucceeded forgets the failures of the login and takes back the attempt of the client address,
+// the address keeps its earlier failures, so a valid account does not help to guess the others
+func (s *ChiServer) loginSucceeded(r *http.Request, login string) {
+	if !loginThrottling() {
+		return
+	}
+	if _, err := s.store.ResetLoginFailures(r.Context(), storage.LoginKey(login)); err != nil {
+		logger.Log.Info("resetting login failures", zap.String("error", err.Error()))
+	}
+	if config.LoginIPMaxFailures > 0 {
+		s.forgiveLoginAttempt(r, loginKey{storage.IPKey(clientIP(r)), ipDelays()})
+	}
+}
@@ -0,0 +1,237 @@
+package chisrv
+
+import (
+	"context"
+	"database/sql"
+	"github.com/zasuchilas/gophermart/internal/gophermart/config"
+	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// loginStore keeps the failed login counters in memory, no login exists
+type loginStore struct {
+	storage.Storage
+	mu       sync.Mutex
+	failures map[string]int
+	locks    map[string]time.Time
+}
+
+func (m *loginStore) GetLoginData(_ context.Context, _, _ string) (*models.LoginData, error) {
+	return &models.LoginData{}, sql.ErrNoRows
+}
+
+func (m *loginStore) AddLoginAttempt(_ context.Context, key string, _ time.Duration, delays []time.Duration) (*models.LoginAttempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.locks[key].After(time.Now()) {
+		return &models.LoginAttempt{Failures: m.failures[key], LockedUntil: m.locks[key], Rejected: true}, nil
+	}
+	m.failures[key]++
+	m.locks[key] = time.Now().Add(delays[min(m.failures[key], len(delays)-1)])
+	return &models.LoginAttempt{Failures: m.failures[key], LockedUntil: m.locks[key]}, nil
+}
+
+func (m *loginStore) ForgiveLoginAttempt(_ context.Context, key string, delays []time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures[key] = max(m.failures[key]-1, 0)
+	if delays[min(m.failures[key], len(delays)-1)] == 0 {
+		delete(m.locks, key)
+	}
+	return nil
+}
+
+func (m *loginStore) ResetLoginFailures(_ context.Context, keys ...string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		delete(m.failures, key)
+		delete(m.locks, key)
+	}
+	return int64(len(keys)), nil
+}
+
+func newLoginStore() *loginStore {
+	return &loginStore{failures: make(map[string]int), locks: make(map[string]time.Time)}
+}
+
+// loginRequest fails to log in as the login from the address
+func loginRequest(s *ChiServer, login, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/user/login", strings.NewReader(`{"login":"`+login+`","password":"wrong password"}`))
+	req.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	s.login(w, req)
+	return w
+}
+
+func TestLoginLockout(t *testing.T) {
+	config.LogLevel = "error"
+	logger.Init()
+	config.LoginMaxFailures = 5
+	config.LoginIPMaxFailures = 100
+	config.LoginDelay = time.Second
+	config.LoginLockout = time.Hour
+
+	store := newLoginStore()
+	s := &ChiServer{store: store}
+	login := func(remoteAddr string) *httptest.ResponseRecorder {
+		return loginRequest(s, "alice", remoteAddr)
+	}
+
+	// the first failures are not delayed
+	for i := 0; i < loginFreeFailures-1; i++ {
+		if w := login("192.0.2.1:1234"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status = %d, want %d", i+1, w.Code, http.StatusUnauthorized)
+		}
+	}
+	if w := login("192.0.2.1:1234"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("delayed failure: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// the login is delayed from any address
+	w := login("198.51.100.1:1234")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("delayed attempt: status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if retryAfter, _ := strconv.Atoi(w.Header().Get("Retry-After")); retryAfter != 1 {
+		t.Errorf("Retry-After = %q, want 1", w.Header().Get("Retry-After"))
+	}
+	if store.failures[storage.LoginKey("alice")] != loginFreeFailures {
+		t.Errorf("the locked attempt was counted: %d failures", store.failures[storage.LoginKey("alice")])
+	}
+
+	// the lockout after the max failures
+	store.locks = make(map[string]time.Time)
+	store.failures[storage.LoginKey("alice")] = config.LoginMaxFailures - 1
+	login("192.0.2.1:1234")
+	w = login("192.0.2.1:1234")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("locked attempt: status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if retryAfter, _ := strconv.Atoi(w.Header().Get("Retry-After")); retryAfter < 3590 || retryAfter > 3600 {
+		t.Errorf("Retry-After = %q, want the lockout", w.Header().Get("Retry-After"))
+	}
+}
+
+// the defaults let the users of a shared address make many typos before the address is locked
+func TestLoginAddressLockout(t *testing.T) {
+	config.LogLevel = "error"
+	logger.Init()
+	config.LoginMaxFailures = 10
+	config.LoginIPMaxFailures = 100
+	config.LoginDelay = time.Second
+	config.LoginLockout = 15 * time.Minute
+
+	store := newLoginStore()
+	s := &ChiServer{store: store}
+	for i := 1; i <= config.LoginIPMaxFailures; i++ {
+		if w := loginRequest(s, "user"+strconv.Itoa(i), "192.0.2.1:1234"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status = %d, want %d", i, w.Code, http.StatusUnauthorized)
+		}
+	}
+
+	w := loginRequest(s, "another", "192.0.2.1:1234")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("attempt after the max failures: status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if retryAfter, _ := strconv.Atoi(w.Header().Get("Retry-After")); retryAfter < 890 || retryAfter > 900 {
+		t.Errorf("Retry-After = %q, want the lockout", w.Header().Get("Retry-After"))
+	}
+	if n := store.failures[storage.LoginKey("another")]; n != 0 {
+		t.Errorf("the login of the locked address was counted: %d failures", n)
+	}
+
+	// the other addresses are not locked
+	if w = loginRequest(s, "another", "198.51.100.1:1234"); w.Code != http.StatusUnauthorized {
+		t.Errorf("another address: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestParallelLoginAttempts(t *testing.T) {
+	config.LogLevel = "error"
+	logger.Init()
+	config.LoginMaxFailures = 10
+	config.LoginIPMaxFailures = 100
+	config.LoginDelay = time.Second
+	config.LoginLockout = 15 * time.Minute
+
+	s := &ChiServer{store: newLoginStore()}
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		checked int
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := loginRequest(s, "alice", "192.0.2."+strconv.Itoa(i+1)+":1234")
+			if w.Code == http.StatusUnauthorized {
+				mu.Lock()
+				checked++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// only the free failures reach the password check, the others find the lock
+	if checked != loginFreeFailures {
+		t.Errorf("%d passwords checked, want %d", checked, loginFreeFailures)
+	}
+}
+
+func TestLoginSucceededForgivesAddress(t *testing.T) {
+	config.LogLevel = "error"
+	logger.Init()
+	config.LoginMaxFailures = 10
+	config.LoginIPMaxFailures = 100
+
+	store := newLoginStore()
+	s := &ChiServer{store: store}
+	req := httptest.NewRequest(http.MethodPost, "/api/user/login", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	loginRequest(s, "alice", req.RemoteAddr)
+
+	if s.loginLocked(httptest.NewRecorder(), req, "bob") {
+		t.Fatal("the attempt is locked")
+	}
+	s.loginSucceeded(req, "bob")
+
+	if n := store.failures[storage.IPKey("192.0.2.1")]; n != 1 {
+		t.Errorf("address failures = %d, want 1", n)
+	}
+	if n := store.failures[storage.LoginKey("bob")]; n != 0 {
+		t.Errorf("login failures = %d, want 0", n)
+	}
+}
+
+func TestLoginDelay(t *testing.T) {
+	config.LoginDelay = time.Second
+	config.LoginLockout = time.Minute
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{loginFreeFailures, time.Second},
+		{loginFreeFailures + 1, 2 * time.Second},
+		{loginFreeFailures + 3, 8 * time.Second},
+		{loginFreeFailures + 6, time.Minute}, // capped
+		{10, time.Minute},                    // locked
+	}
+	for _, tt := range tests {
+		if got := loginDelay(tt.failures, 10); got != tt.want {
+			t.Errorf("loginDelay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
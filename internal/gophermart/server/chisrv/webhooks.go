@@ -0,0 +1,181 @@
+package chisrv
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"github.com/zasuchilas/gophermart/internal/gophermart/webhook"
+	"github.com/zasuchilas/gophermart/pkg/httperr"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+)
+
+func urlParamID(r *http.Request, name string) (int64, error) {
+	id, err := strconv.ParseInt(chi.URLParam(r, name), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, errors.New("the " + name + " must be a positive number")
+	}
+	return id, nil
+}
+
+func (s *ChiServer) createWebhook(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := getUserID(r)
+	if err != nil {
+		httperr.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	// decoding request
+	var req models.WebhookRequest
+	if err = httperr.Decode(r, &req); err != nil {
+		httperr.WriteError(w, r, err)
+		return
+	}
+
+	// validation
+	if err = webhook.ValidateURL(req.URL); err != nil {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, err.Error())
+		return
+	}
+
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		logger.Log.Error("failed to create a webhook secret", zap.String("error", err.Error()))
+		httperr.Status(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	// write into db
+	hook, err := s.store.CreateWebhook(r.Context(), userID, req.URL, secret)
+	if err != nil {
+		writeStorageError(w, r, "writing into db", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	enc := json.NewEncoder(w)
+	if err = enc.Encode(hook); err != nil {
+		logger.Log.Info("error encoding response", zap.String("error", err.Error()))
+	}
+}
+
+func (s *ChiServer) getWebhooks(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := getUserID(r)
+	if err != nil {
+		httperr.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	// reading from db
+	hooks, err := s.store.GetUserWebhooks(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeStorageError(w, r, "reading from db", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err = enc.Encode(hooks); err != nil {
+		logger.Log.Info("error encoding response", zap.String("error", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *ChiServer) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := getUserID(r)
+	if err != nil {
+		httperr.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := urlParamID(r, "id")
+	if err != nil {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, err.Error())
+		return
+	}
+
+	// write into db
+	err = s.store.DeleteWebhook(r.Context(), userID, id)
+	if err != nil {
+		writeStorageError(w, r, "writing into db", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *ChiServer) getWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := getUserID(r)
+	if err != nil {
+		httperr.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := urlParamID(r, "id")
+	if err != nil {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, err.Error())
+		return
+	}
+
+	// reading from db
+	deliveries, err := s.store.GetWebhookDeliveries(r.Context(), userID, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeStorageError(w, r, "reading from db", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err = enc.Encode(deliveries); err != nil {
+		logger.Log.Info("error encoding response", zap.String("error", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *ChiServer) redeliverWebhook(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := getUserID(r)
+	if err != nil {
+		httperr.Status(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := urlParamID(r, "id")
+	if err != nil {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, err.Error())
+		return
+	}
+	deliveryID, err := urlParamID(r, "deliveryID")
+	if err != nil {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeValidation, err.Error())
+		return
+	}
+
+	// write into db, the worker sends it again
+	err = s.store.RedeliverWebhookDelivery(r.Context(), userID, id, deliveryID)
+	if err != nil {
+		writeStorageError(w, r, "writing into db", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,319 @@
+package pgstorage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/Rhymond/go-money"
+	"github.com/zasuchilas/gophermart/internal/common"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"github.com/zasuchilas/gophermart/pkg/amount"
+	"time"
+)
+
+// The manual balance changes of the operators move the amount between the user account
+// and the adjustments account, the restore of a deleted user returns the forfeited balance
+// from the closures account.
+
+const (
+	ledgerOperationAdjustment = "ADJUSTMENT"
+	ledgerOperationRestore    = "RESTORE"
+	ledgerAccountAdjustments  = "adjustments"
+)
+
+const adminUserColumns = "id, login, role, currency, balance, held, withdrawn, deleted, created_at, deleted_at"
+
+func scanAdminUser(row rowScanner) (*models.AdminUser, time.Time, error) {
+	var (
+		v                        models.AdminUser
+		balance, held, withdrawn int64
+		createdAt                time.Time
+		deletedAt                sql.NullTime
+	)
+	err := row.Scan(&v.ID, &v.Login, &v.Role, &v.Currency, &balance, &held, &withdrawn, &v.Deleted, &createdAt, &deletedAt)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	v.Balance = amount.Amount(balance)
+	v.Held = amount.Amount(held)
+	v.Withdrawn = amount.Amount(withdrawn)
+	v.CreatedAt = createdAt.Format(time.RFC3339)
+	if deletedAt.Valid {
+		v.DeletedAt = deletedAt.Time.Format(time.RFC3339)
+	}
+	return &v, createdAt, nil
+}
+
+// GetUsers returns the users with the login containing the search string, the deleted ones as well.
+func (d *PgStorage) GetUsers(ctx context.Context, search string, q *models.ListQuery) ([]*models.AdminUser, string, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query, args := listQuerySQL(
+		`SELECT `+adminUserColumns+` FROM gophermart.users WHERE strpos(lower(login), lower($1)) > 0`,
+		[]any{search}, q, "created_at", "")
+	rows, err := d.db.QueryContext(ctxTm, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var (
+		users = make([]*models.AdminUser, 0)
+		times = make([]time.Time, 0)
+		ids   = make([]int64, 0)
+	)
+	for rows.Next() {
+		v, createdAt, er := scanAdminUser(rows)
+		if er != nil {
+			return nil, "", er
+		}
+		users = append(users, v)
+		times = append(times, createdAt)
+		ids = append(ids, v.ID)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(users) == 0 {
+		return nil, "", storage.ErrNotFound
+	}
+
+	next, count := pageCursor(q, len(users), times, ids)
+	return users[:count], next, nil
+}
+
+func (d *PgStorage) GetUser(ctx context.Context, userID int64) (*models.AdminUser, error) {
+	v, _, err := scanAdminUser(d.db.QueryRowContext(ctx,
+		"SELECT "+adminUserColumns+" FROM gophermart.users WHERE id = $1;", userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, storage.ErrNotFound
+	}
+	return v, err
+}
+
+// SetUserRole changes the role of the user, the access tokens with the previous role are refused
+// by the active session check. It returns the id of the user.
+func (d *PgStorage) SetUserRole(ctx context.Context, login, role string) (int64, error) {
+	var userID int64
+	err := d.db.QueryRowContext(ctx,
+		"UPDATE gophermart.users SET role = $1 WHERE login = $2 RETURNING id;", role, login).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, storage.ErrNotFound
+	}
+	return userID, err
+}
+
+// AdjustBalance credits the positive sum to the user balance or debits the negative one.
+func (d *PgStorage) AdjustBalance(ctx context.Context, userID int64, sum *money.Money, audit *models.AuditEntry) (*models.UserBalance, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctxTm, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		balance  int64
+		currency string
+		deleted  bool
+	)
+	err = tx.QueryRowContext(ctxTm, "SELECT balance, currency, deleted FROM gophermart.users WHERE id = $1 FOR UPDATE;", userID).
+		Scan(&balance, &currency, &deleted)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	if deleted {
+		return nil, storage.ErrGone
+	}
+	if sum.Currency().Code != currency {
+		return nil, storage.ErrCurrencyMismatch
+	}
+
+	e := ledgerEntry{
+		userID:    userID,
+		operation: ledgerOperationAdjustment,
+		debit:     ledgerAccountAdjustments,
+		credit:    ledgerAccountUser,
+		amount:    sum.Amount(),
+	}
+	if sum.IsNegative() {
+		if balance < -sum.Amount() {
+			return nil, storage.ErrNotEnoughFunds
+		}
+		e.debit, e.credit, e.amount = ledgerAccountUser, ledgerAccountAdjustments, -sum.Amount()
+	}
+	if _, err = appendLedgerEntry(ctxTm, tx, e); err != nil {
+		return nil, err
+	}
+
+	audit.UserID = userID
+	audit.Details, err = json.Marshal(map[string]any{"amount": amount.Amount(sum.Amount()), "currency": currency})
+	if err != nil {
+		return nil, err
+	}
+	if err = addAuditEntry(ctxTm, tx, audit); err != nil {
+		return nil, err
+	}
+
+	var held, withdrawn int64
+	err = tx.QueryRowContext(ctxTm, "SELECT balance, held, withdrawn FROM gophermart.users WHERE id = $1;", userID).
+		Scan(&balance, &held, &withdrawn)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return newUserBalance(balance, withdrawn, held, currency), nil
+}
+
+// RepollOrder returns the order to the NEW status, so the worker asks the accrual system again.
+// The processed orders are credited already and cannot be polled again.
+func (d *PgStorage) RepollOrder(ctx context.Context, orderNum string, audit *models.AuditEntry) (*models.Order, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctxTm, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		id, userID int64
+		status     string
+		v          models.Order
+		uploadedAt time.Time
+	)
+	err = tx.QueryRowContext(ctxTm,
+		`SELECT id, user_id, status, `+currencyColumn("user_orders")+`, uploaded_at FROM gophermart.user_orders WHERE order_num = $1 FOR UPDATE;`,
+		orderNum).Scan(&id, &userID, &status, &v.Currency, &uploadedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	if status == common.OrderStatusProcessed {
+		return nil, storage.ErrOrderProcessed
+	}
+
+	_, err = tx.ExecContext(ctxTm,
+		"UPDATE gophermart.user_orders SET status = $1, accrual = 0 WHERE id = $2;", common.OrderStatusNew, id)
+	if err != nil {
+		return nil, err
+	}
+	if err = addOrderStatusHistory(ctxTm, tx, id, common.OrderStatusNew, 0); err != nil {
+		return nil, err
+	}
+	v.OrderNum = orderNum
+	v.Status = common.OrderStatusNew
+	v.UploadedAt = uploadedAt.Format(time.RFC3339)
+	if err = orderChanged(ctxTm, tx, userID, &v); err != nil {
+		return nil, err
+	}
+
+	audit.UserID = userID
+	audit.Details, err = json.Marshal(map[string]any{"order": orderNum, "previous_status": status})
+	if err != nil {
+		return nil, err
+	}
+	if err = addAuditEntry(ctxTm, tx, audit); err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &v, nil
+}
+
+// RestoreUser undoes DeleteUser: the user can log in again and gets back the forfeited balance.
+// The sessions and the released holds are not restored.
+func (d *PgStorage) RestoreUser(ctx context.Context, userID int64, audit *models.AuditEntry) (*models.AdminUser, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctxTm, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var deleted bool
+	err = tx.QueryRowContext(ctxTm, "SELECT deleted FROM gophermart.users WHERE id = $1 FOR UPDATE;", userID).Scan(&deleted)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	if !deleted {
+		return nil, storage.ErrNotDeleted
+	}
+
+	var forfeited int64
+	err = tx.QueryRowContext(ctxTm,
+		`SELECT coalesce(sum(CASE WHEN credit_account = $2 THEN amount ELSE -amount END), 0)
+		FROM gophermart.ledger WHERE user_id = $1 AND $2 IN (debit_account, credit_account);`,
+		userID, ledgerAccountClosures).Scan(&forfeited)
+	if err != nil {
+		return nil, err
+	}
+	if forfeited > 0 {
+		_, err = appendLedgerEntry(ctxTm, tx, ledgerEntry{
+			userID:    userID,
+			operation: ledgerOperationRestore,
+			debit:     ledgerAccountClosures,
+			credit:    ledgerAccountUser,
+			amount:    forfeited,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctxTm,
+		"UPDATE gophermart.users SET deleted = false, deleted_at = NULL WHERE id = $1;", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	audit.UserID = userID
+	audit.Details, err = json.Marshal(map[string]any{"returned": amount.Amount(forfeited)})
+	if err != nil {
+		return nil, err
+	}
+	if err = addAuditEntry(ctxTm, tx, audit); err != nil {
+		return nil, err
+	}
+
+	v, _, err := scanAdminUser(tx.QueryRowContext(ctxTm,
+		"SELECT "+adminUserColumns+" FROM gophermart.users WHERE id = $1;", userID))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
@@ -0,0 +1,181 @@
+package pgstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"strings"
+	"time"
+)
+
+// Only the sha256 hash of an API key is stored, the key itself is shown once on creating.
+// The scopes are kept as TEXT[] and passed as comma separated strings.
+
+const (
+	maxUserAPIKeys = 10
+
+	// apiKeyUsedInterval limits the writes of last_used_at by the busy keys
+	apiKeyUsedInterval = time.Minute
+)
+
+func (d *PgStorage) CreateAPIKey(ctx context.Context, key *models.APIKey, keyHash string, expiresAt time.Time) (*models.APIKey, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctxTm, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// serializing the key creation of the user to keep the limit
+	_, err = tx.ExecContext(ctxTm, "SELECT id FROM gophermart.users WHERE id = $1 FOR UPDATE;", key.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int
+	err = tx.QueryRowContext(ctxTm,
+		`SELECT count(*) FROM gophermart.api_keys
+		WHERE user_id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now());`,
+		key.UserID).Scan(&count)
+	if err != nil {
+		return nil, err
+	}
+	if count >= maxUserAPIKeys {
+		return nil, storage.ErrLimitExceeded
+	}
+
+	var (
+		v         = *key
+		createdAt time.Time
+		expires   sql.NullTime
+	)
+	if !expiresAt.IsZero() {
+		expires = sql.NullTime{Time: expiresAt, Valid: true}
+		v.ExpiresAt = expiresAt.Format(time.RFC3339)
+	}
+	err = tx.QueryRowContext(ctxTm,
+		`INSERT INTO gophermart.api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, string_to_array($5, ','), $6) RETURNING id, created_at;`,
+		v.UserID, v.Name, v.Prefix, keyHash, strings.Join(v.Scopes, ","), expires,
+	).Scan(&v.ID, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	v.CreatedAt = createdAt.Format(time.RFC3339)
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &v, nil
+}
+
+// GetUserAPIKeys returns the not revoked keys of the user, the expired ones are listed as well
+func (d *PgStorage) GetUserAPIKeys(ctx context.Context, userID int64) ([]*models.APIKey, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctxTm,
+		`SELECT id, user_id, name, prefix, array_to_string(scopes, ','), created_at, expires_at, last_used_at
+		FROM gophermart.api_keys WHERE user_id = $1 AND revoked_at IS NULL ORDER BY id`,
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]*models.APIKey, 0)
+	for rows.Next() {
+		v, er := scanAPIKey(rows)
+		if er != nil {
+			return nil, er
+		}
+		keys = append(keys, v)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	return keys, nil
+}
+
+func (d *PgStorage) RevokeAPIKey(ctx context.Context, userID, id int64) error {
+	res, err := d.db.ExecContext(ctx,
+		"UPDATE gophermart.api_keys SET revoked_at = now() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;",
+		id, userID)
+	if err != nil {
+		return err
+	}
+	revoked, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// CheckAPIKey returns the active key by its hash, the keys of the deleted users are not active
+func (d *PgStorage) CheckAPIKey(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	row := d.db.QueryRowContext(ctxTm,
+		`SELECT k.id, k.user_id, k.name, k.prefix, array_to_string(k.scopes, ','), k.created_at, k.expires_at, k.last_used_at
+		FROM gophermart.api_keys k JOIN gophermart.users u ON u.id = k.user_id
+		WHERE k.key_hash = $1 AND k.revoked_at IS NULL AND (k.expires_at IS NULL OR k.expires_at > now())
+		AND u.deleted = false;`,
+		keyHash)
+	v, err := scanAPIKey(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+
+	lastUsedAt, _ := time.Parse(time.RFC3339, v.LastUsedAt)
+	if time.Since(lastUsedAt) > apiKeyUsedInterval {
+		_, err = d.db.ExecContext(ctxTm, "UPDATE gophermart.api_keys SET last_used_at = now() WHERE id = $1;", v.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return v, nil
+}
+
+func scanAPIKey(row rowScanner) (*models.APIKey, error) {
+	var (
+		v          models.APIKey
+		scopes     string
+		createdAt  time.Time
+		expiresAt  sql.NullTime
+		lastUsedAt sql.NullTime
+	)
+	err := row.Scan(&v.ID, &v.UserID, &v.Name, &v.Prefix, &scopes, &createdAt, &expiresAt, &lastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	v.Scopes = strings.Split(scopes, ",")
+	v.CreatedAt = createdAt.Format(time.RFC3339)
+	if expiresAt.Valid {
+		v.ExpiresAt = expiresAt.Time.Format(time.RFC3339)
+	}
+	if lastUsedAt.Valid {
+		v.LastUsedAt = lastUsedAt.Time.Format(time.RFC3339)
+	}
+	return &v, nil
+}
@@ -0,0 +1,85 @@
+package pgstorage
+
+import (
+	"context"
+	"database/sql"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"time"
+)
+
+// The audit log records the operator actions, the changing ones are recorded
+// in the transaction of the change.
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func addAuditEntry(ctx context.Context, db execer, e *models.AuditEntry) error {
+	var userID sql.NullInt64
+	if e.UserID != 0 {
+		userID = sql.NullInt64{Int64: e.UserID, Valid: true}
+	}
+	var details []byte
+	if len(e.Details) > 0 {
+		details = e.Details
+	}
+	_, err := db.ExecContext(ctx,
+		"INSERT INTO gophermart.audit_log (actor, action, user_id, reason, details) VALUES ($1, $2, $3, $4, $5);",
+		e.Actor, e.Action, userID, e.Reason, details)
+	return err
+}
+
+func (d *PgStorage) AddAuditEntry(ctx context.Context, audit *models.AuditEntry) error {
+	return addAuditEntry(ctx, d.db, audit)
+}
+
+// GetAuditLog returns the entries of the user or all of them if userID is 0.
+func (d *PgStorage) GetAuditLog(ctx context.Context, userID int64, q *models.ListQuery) ([]*models.AuditEntry, string, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query, args := listQuerySQL(
+		`SELECT id, actor, action, coalesce(user_id, 0), reason, details, created_at FROM gophermart.audit_log
+		WHERE ($1::int8 = 0 OR user_id = $1::int8)`,
+		[]any{userID}, q, "created_at", "")
+	rows, err := d.db.QueryContext(ctxTm, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var (
+		entries = make([]*models.AuditEntry, 0)
+		times   = make([]time.Time, 0)
+		ids     = make([]int64, 0)
+	)
+	for rows.Next() {
+		var (
+			v         models.AuditEntry
+			details   []byte
+			createdAt time.Time
+		)
+		err = rows.Scan(&v.ID, &v.Actor, &v.Action, &v.UserID, &v.Reason, &details, &createdAt)
+		if err != nil {
+			return nil, "", err
+		}
+		v.Details = details
+		v.CreatedAt = createdAt.Format(time.RFC3339)
+		entries = append(entries, &v)
+		times = append(times, createdAt)
+		ids = append(ids, v.ID)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(entries) == 0 {
+		return nil, "", storage.ErrNotFound
+	}
+
+	next, count := pageCursor(q, len(entries), times, ids)
+	return entries[:count], next, nil
+}
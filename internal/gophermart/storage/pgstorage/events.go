@@ -0,0 +1,173 @@
+package pgstorage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"go.uber.org/zap"
+	"time"
+)
+
+// The user events are written in the same transaction as the change itself
+// and are announced with NOTIFY, which postgresql delivers only after the commit,
+// so the listeners in any gophermart process receive committed events only.
+
+// The event id is taken under the lock of the users row held till the commit,
+// so the events of a user commit in the order of their ids and a replay by id > last id misses nothing.
+// The ids of different users are not in the commit order, so the catch-up of all the events
+// (GetEvents) starts eventsOverlap before the last id, longer than any transaction writing the events
+// (10 seconds at most), and the subscribers skip the ids of the user they have sent.
+
+const (
+	eventsChannel = "gophermart_events"
+	eventsOverlap = 15 * time.Second
+)
+
+type eventNotification struct {
+	ID        int64           `json:"id"`
+	UserID    int64           `json:"user_id"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+func (n *eventNotification) toEvent() *models.Event {
+	return &models.Event{
+		ID:        n.ID,
+		UserID:    n.UserID,
+		Type:      n.Type,
+		Data:      n.Data,
+		CreatedAt: n.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func addEvent(ctx context.Context, tx *sql.Tx, userID int64, eventType string, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	n := eventNotification{
+		UserID: userID,
+		Type:   eventType,
+		Data:   b,
+	}
+	_, err = tx.ExecContext(ctx, "SELECT id FROM gophermart.users WHERE id = $1 FOR UPDATE;", userID)
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO gophermart.events (user_id, type, data) VALUES ($1, $2, $3) RETURNING id, created_at;",
+		userID, eventType, string(b),
+	).Scan(&n.ID, &n.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "SELECT pg_notify($1, $2);", eventsChannel, string(payload))
+	return err
+}
+
+func (d *PgStorage) GetUserEvents(ctx context.Context, userID, afterID int64) ([]*models.Event, error) {
+	return d.queryEvents(ctx,
+		`SELECT id, user_id, type, data, created_at FROM gophermart.events WHERE user_id = $1 AND id > $2 ORDER BY id`,
+		userID, afterID)
+}
+
+// GetEvents returns the events of all the users after the id and the events of the overlap before it.
+func (d *PgStorage) GetEvents(ctx context.Context, afterID int64) ([]*models.Event, error) {
+	return d.queryEvents(ctx,
+		`SELECT id, user_id, type, data, created_at FROM gophermart.events
+		WHERE id > $1
+		  OR created_at >= (SELECT created_at FROM gophermart.events WHERE id = $1) - make_interval(secs => $2)
+		ORDER BY id`,
+		afterID, eventsOverlap.Seconds())
+}
+
+func (d *PgStorage) queryEvents(ctx context.Context, query string, args ...any) ([]*models.Event, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctxTm, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]*models.Event, 0)
+	for rows.Next() {
+		var (
+			n    eventNotification
+			data string
+		)
+		err = rows.Scan(&n.ID, &n.UserID, &n.Type, &data, &n.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		n.Data = json.RawMessage(data)
+		events = append(events, n.toEvent())
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (d *PgStorage) DeleteEventsBefore(ctx context.Context, before time.Time) (int64, error) {
+	res, err := d.db.ExecContext(ctx, "DELETE FROM gophermart.events WHERE created_at < $1;", before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ListenEvents calls the handler for every event committed by any gophermart process.
+// It blocks until the context is canceled or the listening connection fails.
+func (d *PgStorage) ListenEvents(ctx context.Context, handler func(*models.Event)) error {
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		sc, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+		pc := sc.Conn()
+
+		_, err = pc.Exec(ctx, "LISTEN "+eventsChannel)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			// the connection returns to the pool
+			_, _ = pc.Exec(context.Background(), "UNLISTEN "+eventsChannel)
+		}()
+
+		for {
+			notification, er := pc.WaitForNotification(ctx)
+			if er != nil {
+				return er
+			}
+			var n eventNotification
+			if er = json.Unmarshal([]byte(notification.Payload), &n); er != nil {
+				logger.Log.Info("cannot decode event notification", zap.String("error", er.Error()))
+				continue
+			}
+			handler(n.toEvent())
+		}
+	})
+}
@@ -0,0 +1,349 @@
+package pgstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/Rhymond/go-money"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"github.com/zasuchilas/gophermart/pkg/amount"
+	"time"
+)
+
+// A hold moves the amount from the user account to the holds account,
+// the capture moves it on to the withdrawals account (with a withdrawals row),
+// the release and the expiry return it to the user account.
+// The users row is locked before the holds of the user everywhere (DeleteUser releases the holds
+// under the user lock), so the concurrent transactions do not deadlock.
+
+type holdRow struct {
+	id           int64
+	userID       int64
+	orderNum     string
+	amount       int64
+	currency     string
+	status       string
+	expiresAt    time.Time
+	createdAt    time.Time
+	finishedAt   sql.NullTime
+	withdrawalID sql.NullInt64
+}
+
+var holdColumns = "id, user_id, order_num, amount, " + currencyColumn("holds") + ", status, expires_at, created_at, finished_at, withdrawal_id"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanHold(row rowScanner) (*holdRow, error) {
+	var r holdRow
+	err := row.Scan(&r.id, &r.userID, &r.orderNum, &r.amount, &r.currency, &r.status, &r.expiresAt, &r.createdAt,
+		&r.finishedAt, &r.withdrawalID)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (r *holdRow) toHold() *models.Hold {
+	v := &models.Hold{
+		ID:           r.id,
+		OrderNum:     r.orderNum,
+		Sum:          amount.Amount(r.amount),
+		Currency:     r.currency,
+		Status:       r.status,
+		ExpiresAt:    r.expiresAt.Format(time.RFC3339),
+		CreatedAt:    r.createdAt.Format(time.RFC3339),
+		WithdrawalID: r.withdrawalID.Int64,
+	}
+	if r.finishedAt.Valid {
+		v.FinishedAt = r.finishedAt.Time.Format(time.RFC3339)
+	}
+	return v
+}
+
+func (d *PgStorage) CreateHold(ctx context.Context, userID int64, orderNum string, sum *money.Money, expiresAt time.Time) (*models.Hold, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctxTm, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// checked under the lock the same way as WithdrawTransaction
+	var (
+		balance  int64
+		currency string
+	)
+	err = tx.QueryRowContext(ctxTm, "SELECT balance, currency FROM gophermart.users WHERE id = $1 FOR UPDATE;", userID).
+		Scan(&balance, &currency)
+	if err != nil {
+		return nil, err
+	}
+	if sum.Currency().Code != currency {
+		return nil, storage.ErrCurrencyMismatch
+	}
+	if balance < sum.Amount() {
+		return nil, storage.ErrNotEnoughFunds
+	}
+
+	r, err := scanHold(tx.QueryRowContext(ctxTm,
+		`INSERT INTO gophermart.holds (user_id, order_num, amount, expires_at) VALUES ($1, $2, $3, $4)
+		RETURNING `+holdColumns+`;`,
+		userID, orderNum, sum.Amount(), expiresAt))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = appendLedgerEntry(ctxTm, tx, ledgerEntry{
+		userID:    userID,
+		operation: ledgerOperationHold,
+		debit:     ledgerAccountUser,
+		credit:    ledgerAccountHolds,
+		amount:    r.amount,
+		orderNum:  orderNum,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.toHold(), nil
+}
+
+func (d *PgStorage) GetUserHolds(ctx context.Context, userID int64) ([]*models.Hold, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctxTm,
+		"SELECT "+holdColumns+" FROM gophermart.holds WHERE user_id = $1 ORDER BY id DESC LIMIT 100", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := make([]*models.Hold, 0)
+	for rows.Next() {
+		r, er := scanHold(rows)
+		if er != nil {
+			return nil, er
+		}
+		holds = append(holds, r.toHold())
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(holds) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	return holds, nil
+}
+
+// CaptureHold turns the active hold into a withdrawal.
+func (d *PgStorage) CaptureHold(ctx context.Context, userID, id int64) (*models.Withdrawal, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctxTm, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	r, err := lockActiveHold(ctxTm, tx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	w := withdrawalRow{userID: userID, orderNum: r.orderNum, amount: r.amount, currency: r.currency}
+	err = tx.QueryRowContext(ctxTm,
+		"INSERT INTO gophermart.withdrawals (user_id, order_num, amount) VALUES ($1, $2, $3) RETURNING id, processed_at;",
+		userID, r.orderNum, r.amount,
+	).Scan(&w.id, &w.processedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctxTm,
+		"UPDATE gophermart.holds SET status = $1, finished_at = now(), withdrawal_id = $2 WHERE id = $3;",
+		models.HoldStatusCaptured, w.id, r.id)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = appendLedgerEntry(ctxTm, tx, ledgerEntry{
+		userID:    userID,
+		operation: ledgerOperationCapture,
+		debit:     ledgerAccountHolds,
+		credit:    ledgerAccountWithdrawals,
+		amount:    r.amount,
+		orderNum:  r.orderNum,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return w.toWithdrawal(), nil
+}
+
+func (d *PgStorage) ReleaseHold(ctx context.Context, userID, id int64) (*models.Hold, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctxTm, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	r, err := lockActiveHold(ctxTm, tx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err = finishHold(ctxTm, tx, r, models.HoldStatusReleased)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.toHold(), nil
+}
+
+// ExpireHolds releases the expired holds and returns the number of them.
+// The holds of the users locked by a concurrent transaction are skipped till the next run.
+func (d *PgStorage) ExpireHolds(ctx context.Context, limit int) (int, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctxTm, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// the users first, then their holds
+	userIDs := make([]int64, 0)
+	rows, err := tx.QueryContext(ctxTm,
+		`SELECT id FROM gophermart.users
+		WHERE id IN (SELECT user_id FROM gophermart.holds WHERE status = $1 AND expires_at <= now() ORDER BY expires_at LIMIT $2)
+		ORDER BY id FOR UPDATE SKIP LOCKED`,
+		models.HoldStatusActive, limit)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		userIDs = append(userIDs, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(userIDs) == 0 {
+		return 0, nil
+	}
+
+	rows, err = tx.QueryContext(ctxTm,
+		`SELECT `+holdColumns+` FROM gophermart.holds WHERE user_id = any($1) AND status = $2 AND expires_at <= now()
+		ORDER BY expires_at LIMIT $3 FOR UPDATE`,
+		userIDs, models.HoldStatusActive, limit)
+	if err != nil {
+		return 0, err
+	}
+	expired := make([]*holdRow, 0)
+	for rows.Next() {
+		r, er := scanHold(rows)
+		if er != nil {
+			rows.Close()
+			return 0, er
+		}
+		expired = append(expired, r)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, r := range expired {
+		if _, err = finishHold(ctxTm, tx, r, models.HoldStatusExpired); err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(expired), nil
+}
+
+// lockActiveHold locks the user and the hold, it returns the hold if it can be captured or released.
+func lockActiveHold(ctx context.Context, tx *sql.Tx, userID, id int64) (*holdRow, error) {
+	_, err := tx.ExecContext(ctx, "SELECT id FROM gophermart.users WHERE id = $1 FOR UPDATE;", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := scanHold(tx.QueryRowContext(ctx,
+		"SELECT "+holdColumns+" FROM gophermart.holds WHERE id = $1 AND user_id = $2 FOR UPDATE;", id, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	if r.status != models.HoldStatusActive || !r.expiresAt.After(time.Now()) {
+		return nil, storage.ErrHoldNotActive
+	}
+	return r, nil
+}
+
+// finishHold returns the held amount to the user balance
+func finishHold(ctx context.Context, tx *sql.Tx, r *holdRow, status string) (*holdRow, error) {
+	err := tx.QueryRowContext(ctx,
+		"UPDATE gophermart.holds SET status = $1, finished_at = now() WHERE id = $2 RETURNING status, finished_at;",
+		status, r.id,
+	).Scan(&r.status, &r.finishedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = appendLedgerEntry(ctx, tx, ledgerEntry{
+		userID:    r.userID,
+		operation: ledgerOperationRelease,
+		debit:     ledgerAccountHolds,
+		credit:    ledgerAccountUser,
+		amount:    r.amount,
+		orderNum:  r.orderNum,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,116 @@
+package pgstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"time"
+)
+
+// the request in progress is considered abandoned (the process died) after this time
+const idempotencyLockTimeout = time.Minute
+
+// StartIdempotentRequest reserves the key for the request.
+// It returns the stored response if the same request was already done,
+// nil if the request must be processed now.
+// The expired keys of the user are removed here as well.
+func (d *PgStorage) StartIdempotentRequest(ctx context.Context, userID int64, key, requestHash string, retention time.Duration) (*models.IdempotentResponse, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctxTm, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctxTm,
+		"DELETE FROM gophermart.idempotency_keys WHERE user_id = $1 AND created_at < $2;",
+		userID, time.Now().Add(-retention))
+	if err != nil {
+		return nil, err
+	}
+
+	// the concurrent request with the same key waits here for the first one to commit
+	res, err := tx.ExecContext(ctxTm,
+		`INSERT INTO gophermart.idempotency_keys (user_id, idempotency_key, request_hash) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, idempotency_key) DO NOTHING;`,
+		userID, key, requestHash)
+	if err != nil {
+		return nil, err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if inserted == 1 {
+		return nil, tx.Commit()
+	}
+
+	var (
+		storedHash  string
+		statusCode  sql.NullInt64
+		contentType string
+		body        []byte
+		updatedAt   time.Time
+	)
+	err = tx.QueryRowContext(ctxTm,
+		`SELECT request_hash, status_code, content_type, body, updated_at FROM gophermart.idempotency_keys
+		WHERE user_id = $1 AND idempotency_key = $2 FOR UPDATE;`,
+		userID, key,
+	).Scan(&storedHash, &statusCode, &contentType, &body, &updatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// the first request failed and released the key meanwhile
+			return nil, storage.ErrIdempotencyKeyInProgress
+		}
+		return nil, err
+	}
+
+	if storedHash != requestHash {
+		return nil, storage.ErrIdempotencyKeyReused
+	}
+
+	if !statusCode.Valid {
+		if time.Since(updatedAt) < idempotencyLockTimeout {
+			return nil, storage.ErrIdempotencyKeyInProgress
+		}
+		_, err = tx.ExecContext(ctxTm,
+			"UPDATE gophermart.idempotency_keys SET updated_at = now() WHERE user_id = $1 AND idempotency_key = $2;",
+			userID, key)
+		if err != nil {
+			return nil, err
+		}
+		return nil, tx.Commit()
+	}
+
+	return &models.IdempotentResponse{
+		StatusCode:  int(statusCode.Int64),
+		ContentType: contentType,
+		Body:        body,
+	}, nil
+}
+
+func (d *PgStorage) SaveIdempotentResponse(ctx context.Context, userID int64, key string, resp *models.IdempotentResponse) error {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctxTm,
+		`UPDATE gophermart.idempotency_keys SET status_code = $1, content_type = $2, body = $3, updated_at = now()
+		WHERE user_id = $4 AND idempotency_key = $5;`,
+		resp.StatusCode, resp.ContentType, resp.Body, userID, key)
+	return err
+}
+
+// DeleteIdempotencyKey releases the key of the failed request, so the client can retry it.
+func (d *PgStorage) DeleteIdempotencyKey(ctx context.Context, userID int64, key string) error {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctxTm,
+		"DELETE FROM gophermart.idempotency_keys WHERE user_id = $1 AND idempotency_key = $2 AND status_code IS NULL;",
+		userID, key)
+	return err
+}
@@ -0,0 +1,162 @@
+package pgstorage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"github.com/zasuchilas/gophermart/pkg/amount"
+	"time"
+)
+
+// Every balance change is an append-only ledger entry that moves the amount
+// from the debit account to the credit account. The users.balance and users.withdrawn
+// columns are a cache of the user and withdrawals accounts and are changed only here,
+// as well as users.held of the holds account.
+
+const (
+	ledgerOperationAccrual    = "ACCRUAL"
+	ledgerOperationWithdrawal = "WITHDRAWAL"
+	ledgerOperationReversal   = "REVERSAL"
+	ledgerOperationHold       = "HOLD"
+	ledgerOperationCapture    = "CAPTURE"
+	ledgerOperationRelease    = "RELEASE"
+
+	ledgerAccountUser        = "user"
+	ledgerAccountAccruals    = "accruals"
+	ledgerAccountWithdrawals = "withdrawals"
+	ledgerAccountHolds       = "holds"
+)
+
+type ledgerEntry struct {
+	userID    int64
+	operation string
+	debit     string
+	credit    string
+	amount    int64
+	orderNum  string
+}
+
+func appendLedgerEntry(ctx context.Context, tx *sql.Tx, e ledgerEntry) (balance int64, err error) {
+	if e.amount <= 0 {
+		return 0, fmt.Errorf("ledger entry amount must be positive (amount %d)", e.amount)
+	}
+
+	var balanceDelta, withdrawnDelta, heldDelta int64
+	if e.credit == ledgerAccountUser {
+		balanceDelta += e.amount
+	}
+	if e.debit == ledgerAccountUser {
+		balanceDelta -= e.amount
+	}
+	if e.credit == ledgerAccountWithdrawals {
+		withdrawnDelta += e.amount
+	}
+	if e.debit == ledgerAccountWithdrawals {
+		withdrawnDelta -= e.amount
+	}
+	if e.credit == ledgerAccountHolds {
+		heldDelta += e.amount
+	}
+	if e.debit == ledgerAccountHolds {
+		heldDelta -= e.amount
+	}
+
+	var (
+		withdrawn, held int64
+		currency        string
+	)
+	err = tx.QueryRowContext(ctx,
+		`UPDATE gophermart.users SET balance = balance + $1, withdrawn = withdrawn + $2, held = held + $3
+		WHERE id = $4 RETURNING balance, withdrawn, held, currency;`,
+		balanceDelta, withdrawnDelta, heldDelta, e.userID,
+	).Scan(&balance, &withdrawn, &held, &currency)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO gophermart.ledger (user_id, operation, debit_account, credit_account, amount, balance, order_num)
+		VALUES ($1, $2, $3, $4, $5, $6, $7);`,
+		e.userID, e.operation, e.debit, e.credit, e.amount, balance, e.orderNum,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	err = addEvent(ctx, tx, e.userID, models.EventTypeBalance, newUserBalance(balance, withdrawn, held, currency))
+	if err != nil {
+		return 0, err
+	}
+
+	return balance, nil
+}
+
+// newUserBalance makes the balance of the users row, users.balance is the available amount
+func newUserBalance(balance, withdrawn, held int64, currency string) *models.UserBalance {
+	return &models.UserBalance{
+		Current:   amount.Amount(balance + held),
+		Available: amount.Amount(balance),
+		Held:      amount.Amount(held),
+		Withdrawn: amount.Amount(withdrawn),
+		Currency:  currency,
+	}
+}
+
+func (d *PgStorage) GetUserLedger(ctx context.Context, userID int64) ([]*models.LedgerEntry, error) {
+
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt, err := d.db.PrepareContext(ctxTm,
+		`SELECT id, operation, debit_account, credit_account, amount, balance, `+currencyColumn("ledger")+`, order_num, created_at
+		FROM gophermart.ledger WHERE user_id = $1 ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	select {
+	case <-ctxTm.Done():
+		return nil, fmt.Errorf("the operation was canceled")
+	default:
+		rows, er := stmt.QueryContext(ctxTm, userID)
+		if er != nil {
+			return nil, er
+		}
+		defer rows.Close()
+
+		entries := make([]*models.LedgerEntry, 0)
+		for rows.Next() {
+			var (
+				v         models.LedgerEntry
+				minor     int64
+				balance   int64
+				createdAt time.Time
+			)
+			err = rows.Scan(&v.ID, &v.Operation, &v.Debit, &v.Credit, &minor, &balance, &v.Currency, &v.OrderNum, &createdAt)
+			if err != nil {
+				return nil, err
+			}
+			if v.Debit == ledgerAccountUser {
+				minor = -minor
+			}
+			v.Amount = amount.Amount(minor)
+			v.Balance = amount.Amount(balance)
+			v.CreatedAt = createdAt.Format(time.RFC3339)
+			entries = append(entries, &v)
+		}
+
+		err = rows.Err()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(entries) == 0 {
+			return nil, storage.ErrNotFound
+		}
+
+		return entries, nil
+	}
+}
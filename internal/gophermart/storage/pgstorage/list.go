@@ -0,0 +1,57 @@
+package pgstorage
+
+import (
+	"fmt"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"strings"
+	"time"
+)
+
+// listQuerySQL appends the filters, the keyset condition, the order and the limit
+// to the list query ending with a WHERE clause. One more row than the limit is requested
+// to know whether the next page exists.
+func listQuerySQL(query string, args []any, q *models.ListQuery, timeColumn, statusColumn string) (string, []any) {
+	var sb strings.Builder
+	sb.WriteString(query)
+
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if len(q.Statuses) > 0 && statusColumn != "" {
+		fmt.Fprintf(&sb, " AND %s = any(%s)", statusColumn, arg(q.Statuses))
+	}
+	if !q.From.IsZero() {
+		fmt.Fprintf(&sb, " AND %s >= %s", timeColumn, arg(q.From))
+	}
+	if !q.To.IsZero() {
+		fmt.Fprintf(&sb, " AND %s < %s", timeColumn, arg(q.To))
+	}
+
+	direction, cmp := "DESC", "<"
+	if q.Asc {
+		direction, cmp = "ASC", ">"
+	}
+	if q.Cursor != nil {
+		fmt.Fprintf(&sb, " AND (%s, id) %s (%s, %s)", timeColumn, cmp, arg(q.Cursor.Time), arg(q.Cursor.ID))
+	}
+	fmt.Fprintf(&sb, " ORDER BY %s %s, id %s", timeColumn, direction, direction)
+
+	if q.Limit > 0 {
+		fmt.Fprintf(&sb, " LIMIT %s", arg(q.Limit+1))
+	}
+
+	return sb.String(), args
+}
+
+// pageCursor returns the cursor of the next page (or an empty string)
+// and the number of rows belonging to the current page.
+func pageCursor(q *models.ListQuery, count int, times []time.Time, ids []int64) (string, int) {
+	if q.Limit <= 0 || count <= q.Limit {
+		return "", count
+	}
+	last := q.Limit - 1
+	c := models.Cursor{Time: times[last], ID: ids[last]}
+	return c.Encode(), q.Limit
+}
@@ -0,0 +1,84 @@
+package pgstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"time"
+)
+
+// The failed logins are counted per login and per client address in the database,
+// so all the gophermart processes share the counters (the keys are storage.LoginKey and storage.IPKey).
+// An attempt is counted as a failure before the password is checked and forgiven when it succeeds,
+// so the parallel attempts cannot all pass the check before the first failure is counted.
+
+// delaySeconds is the lock schedule for the query, delays[n] is the lock after n failures
+func delaySeconds(delays []time.Duration) []float64 {
+	secs := make([]float64, len(delays))
+	for i, d := range delays {
+		secs[i] = d.Seconds()
+	}
+	return secs
+}
+
+// AddLoginAttempt counts the attempt of the unlocked key and locks the key for delays[failures]
+// (the last delay for more failures) in one statement. The attempts of a locked key are rejected
+// and not counted, the counter starts again when the previous failure is older than the window.
+func (d *PgStorage) AddLoginAttempt(ctx context.Context, key string, window time.Duration, delays []time.Duration) (*models.LoginAttempt, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var attempt models.LoginAttempt
+	err := d.db.QueryRowContext(ctxTm,
+		`INSERT INTO gophermart.login_failures AS f (key, failures, locked_until)
+		VALUES ($1, 1, now() + make_interval(secs => ($3::float8[])[LEAST(1, cardinality($3::float8[]) - 1) + 1]))
+		ON CONFLICT (key) DO UPDATE SET
+		  failures = CASE WHEN f.last_failure_at < now() - make_interval(secs => $2) THEN 1 ELSE f.failures + 1 END,
+		  last_failure_at = now(),
+		  locked_until = now() + make_interval(secs => ($3::float8[])[
+		    LEAST(CASE WHEN f.last_failure_at < now() - make_interval(secs => $2) THEN 1 ELSE f.failures + 1 END,
+		      cardinality($3::float8[]) - 1) + 1])
+		WHERE f.locked_until IS NULL OR f.locked_until <= now()
+		RETURNING failures, locked_until;`,
+		key, window.Seconds(), delaySeconds(delays),
+	).Scan(&attempt.Failures, &attempt.LockedUntil)
+	if err == nil {
+		return &attempt, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// the key is locked
+	attempt.Rejected = true
+	err = d.db.QueryRowContext(ctxTm,
+		"SELECT failures, locked_until FROM gophermart.login_failures WHERE key = $1;",
+		key).Scan(&attempt.Failures, &attempt.LockedUntil)
+	return &attempt, err
+}
+
+// ForgiveLoginAttempt takes back the attempt of the key counted by AddLoginAttempt,
+// the lock stays only if the remaining failures are locked by the schedule.
+func (d *PgStorage) ForgiveLoginAttempt(ctx context.Context, key string, delays []time.Duration) error {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctxTm,
+		`UPDATE gophermart.login_failures SET
+		  failures = GREATEST(failures - 1, 0),
+		  locked_until = CASE WHEN ($2::float8[])[LEAST(GREATEST(failures - 1, 0), cardinality($2::float8[]) - 1) + 1] > 0
+		    THEN locked_until END
+		WHERE key = $1;`,
+		key, delaySeconds(delays))
+	return err
+}
+
+// ResetLoginFailures removes the counters and the locks of the keys and returns the number of them.
+func (d *PgStorage) ResetLoginFailures(ctx context.Context, keys ...string) (int64, error) {
+	res, err := d.db.ExecContext(ctx, "DELETE FROM gophermart.login_failures WHERE key = any($1);", keys)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
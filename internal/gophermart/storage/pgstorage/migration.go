@@ -38,6 +38,22 @@ func createTablesIfNeed(db *sql.DB) {
 			uploaded_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
 		);
 		CREATE INDEX IF NOT EXISTS idx_status ON gophermart.user_orders (status);
+		CREATE INDEX IF NOT EXISTS idx_user_orders_user_id ON gophermart.user_orders (user_id, uploaded_at, id);
+
+		CREATE TABLE IF NOT EXISTS gophermart.order_status_history (
+		  id BIGSERIAL PRIMARY KEY,
+		  order_id INT8 NOT NULL REFERENCES gophermart.user_orders (id),
+		  status VARCHAR(25) NOT NULL,
+		  accrual INTEGER NOT NULL DEFAULT 0,
+		  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+		);
+		CREATE INDEX IF NOT EXISTS idx_order_status_history_order_id ON gophermart.order_status_history (order_id, id);
+
+		-- orders uploaded before the history appeared
+		INSERT INTO gophermart.order_status_history (order_id, status, accrual, created_at)
+		SELECT o.id, o.status, o.accrual, o.uploaded_at
+		FROM gophermart.user_orders o
+		WHERE NOT EXISTS (SELECT 1 FROM gophermart.order_status_history h WHERE h.order_id = o.id);
 
 		CREATE TABLE IF NOT EXISTS gophermart.withdrawals (
 		  id SERIAL PRIMARY KEY,
@@ -46,6 +62,185 @@ func createTablesIfNeed(db *sql.DB) {
 		  amount INT NOT NULL DEFAULT 0,
 		  processed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
 		);
+		CREATE INDEX IF NOT EXISTS idx_withdrawals_user_id ON gophermart.withdrawals (user_id, processed_at, id);
+		ALTER TABLE gophermart.withdrawals ADD COLUMN IF NOT EXISTS reversed_at TIMESTAMP WITH TIME ZONE;
+		ALTER TABLE gophermart.withdrawals ADD COLUMN IF NOT EXISTS reversed_by VARCHAR(25) NOT NULL DEFAULT '';
+		ALTER TABLE gophermart.withdrawals ADD COLUMN IF NOT EXISTS reversal_reason VARCHAR(254) NOT NULL DEFAULT '';
+
+		CREATE TABLE IF NOT EXISTS gophermart.events (
+		  id BIGSERIAL PRIMARY KEY,
+		  user_id INT8 NOT NULL REFERENCES gophermart.users (id),
+		  type VARCHAR(25) NOT NULL,
+		  data JSONB NOT NULL,
+		  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+		);
+		CREATE INDEX IF NOT EXISTS idx_events_user_id ON gophermart.events (user_id, id);
+		CREATE INDEX IF NOT EXISTS idx_events_created_at ON gophermart.events (created_at);
+
+		CREATE TABLE IF NOT EXISTS gophermart.webhooks (
+		  id SERIAL PRIMARY KEY,
+		  user_id INT8 NOT NULL REFERENCES gophermart.users (id),
+		  url VARCHAR(2048) NOT NULL,
+		  secret VARCHAR(254) NOT NULL,
+		  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		  deleted BOOL NOT NULL DEFAULT false
+		);
+		CREATE INDEX IF NOT EXISTS idx_webhooks_user_id ON gophermart.webhooks (user_id);
+
+		CREATE TABLE IF NOT EXISTS gophermart.webhook_deliveries (
+		  id BIGSERIAL PRIMARY KEY,
+		  webhook_id INT8 NOT NULL REFERENCES gophermart.webhooks (id),
+		  event VARCHAR(50) NOT NULL,
+		  payload JSONB NOT NULL,
+		  status VARCHAR(25) NOT NULL DEFAULT 'PENDING',
+		  attempts INT NOT NULL DEFAULT 0,
+		  next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		  last_status_code INT NOT NULL DEFAULT 0,
+		  last_error TEXT NOT NULL DEFAULT '',
+		  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		  delivered_at TIMESTAMP WITH TIME ZONE
+		);
+		CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON gophermart.webhook_deliveries (webhook_id, id);
+		CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_pending ON gophermart.webhook_deliveries (next_attempt_at)
+		  WHERE status = 'PENDING';
+		CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_created_at ON gophermart.webhook_deliveries (created_at);
+
+		CREATE TABLE IF NOT EXISTS gophermart.webhook_attempts (
+		  id BIGSERIAL PRIMARY KEY,
+		  delivery_id INT8 NOT NULL REFERENCES gophermart.webhook_deliveries (id),
+		  status_code INT NOT NULL DEFAULT 0,
+		  error TEXT NOT NULL DEFAULT '',
+		  duration_ms INT8 NOT NULL DEFAULT 0,
+		  attempted_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+		);
+		CREATE INDEX IF NOT EXISTS idx_webhook_attempts_delivery_id ON gophermart.webhook_attempts (delivery_id, id);
+
+		CREATE TABLE IF NOT EXISTS gophermart.ledger (
+		  id BIGSERIAL PRIMARY KEY,
+		  user_id INT8 NOT NULL REFERENCES gophermart.users (id),
+		  operation VARCHAR(25) NOT NULL,
+		  debit_account VARCHAR(25) NOT NULL,
+		  credit_account VARCHAR(25) NOT NULL,
+		  amount INTEGER NOT NULL CHECK (amount > 0),
+		  balance INTEGER NOT NULL, -- user balance after the entry
+		  order_num VARCHAR(254) NOT NULL DEFAULT '',
+		  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+		);
+		CREATE INDEX IF NOT EXISTS idx_ledger_user_id ON gophermart.ledger (user_id, id);
+
+		CREATE OR REPLACE FUNCTION gophermart.ledger_append_only() RETURNS trigger AS $$
+		BEGIN
+		  RAISE EXCEPTION 'gophermart.ledger is append-only';
+		END;
+		$$ LANGUAGE plpgsql;
+		DROP TRIGGER IF EXISTS trg_ledger_append_only ON gophermart.ledger;
+		CREATE TRIGGER trg_ledger_append_only BEFORE UPDATE OR DELETE ON gophermart.ledger
+		  FOR EACH ROW EXECUTE FUNCTION gophermart.ledger_append_only();
+
+		-- the balance and the withdrawn counters accumulated before the ledger appeared,
+		-- the ledger row balance is the user account after the entry
+		INSERT INTO gophermart.ledger (user_id, operation, debit_account, credit_account, amount, balance)
+		SELECT o.user_id, 'OPENING', 'opening', o.account, o.amount, o.balance
+		FROM (
+		  SELECT u.id AS user_id, 'user' AS account, u.balance AS amount, u.balance, 1 AS n
+		  FROM gophermart.users u WHERE u.balance > 0
+		  UNION ALL
+		  SELECT u.id, 'withdrawals', u.withdrawn, u.balance, 2
+		  FROM gophermart.users u WHERE u.withdrawn > 0
+		) o
+		WHERE NOT EXISTS (SELECT 1 FROM gophermart.ledger l WHERE l.user_id = o.user_id)
+		ORDER BY o.user_id, o.n;
+
+		ALTER TABLE gophermart.users ADD COLUMN IF NOT EXISTS held INTEGER NOT NULL DEFAULT 0;
+
+		CREATE TABLE IF NOT EXISTS gophermart.holds (
+		  id BIGSERIAL PRIMARY KEY,
+		  user_id INT8 NOT NULL REFERENCES gophermart.users (id),
+		  order_num VARCHAR(254) NOT NULL,
+		  amount INT NOT NULL CHECK (amount > 0),
+		  status VARCHAR(25) NOT NULL DEFAULT 'ACTIVE',
+		  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		  finished_at TIMESTAMP WITH TIME ZONE,
+		  withdrawal_id INT REFERENCES gophermart.withdrawals (id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_holds_user_id ON gophermart.holds (user_id, id);
+		CREATE INDEX IF NOT EXISTS idx_holds_active ON gophermart.holds (expires_at) WHERE status = 'ACTIVE';
+
+		CREATE TABLE IF NOT EXISTS gophermart.idempotency_keys (
+		  user_id INT8 NOT NULL REFERENCES gophermart.users (id),
+		  idempotency_key VARCHAR(255) NOT NULL,
+		  request_hash VARCHAR(64) NOT NULL,
+		  status_code INT, -- NULL while the request is in progress
+		  content_type VARCHAR(254) NOT NULL DEFAULT '',
+		  body BYTEA,
+		  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		  PRIMARY KEY (user_id, idempotency_key)
+		);
+
+		-- the accounts created before the currency appeared are in roubles
+		ALTER TABLE gophermart.users ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'RUB';
+
+		CREATE TABLE IF NOT EXISTS gophermart.sessions (
+		  id BIGSERIAL PRIMARY KEY,
+		  user_id INT8 NOT NULL REFERENCES gophermart.users (id),
+		  refresh_hash VARCHAR(64) NOT NULL UNIQUE,
+		  previous_hash VARCHAR(64), -- the rotated refresh token, its reuse revokes the session
+		  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		  refreshed_at TIMESTAMP WITH TIME ZONE,
+		  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		  revoked_at TIMESTAMP WITH TIME ZONE
+		);
+		CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON gophermart.sessions (user_id);
+		CREATE INDEX IF NOT EXISTS idx_sessions_previous_hash ON gophermart.sessions (previous_hash);
+
+		ALTER TABLE gophermart.users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+
+		CREATE TABLE IF NOT EXISTS gophermart.password_resets (
+		  id BIGSERIAL PRIMARY KEY,
+		  user_id INT8 NOT NULL REFERENCES gophermart.users (id),
+		  token_hash VARCHAR(64) NOT NULL UNIQUE,
+		  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		  used_at TIMESTAMP WITH TIME ZONE
+		);
+		CREATE INDEX IF NOT EXISTS idx_password_resets_user_id ON gophermart.password_resets (user_id);
+
+		CREATE TABLE IF NOT EXISTS gophermart.login_failures (
+		  key VARCHAR(300) PRIMARY KEY, -- login:<login> or ip:<address>
+		  failures INT NOT NULL DEFAULT 0,
+		  last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		  locked_until TIMESTAMP WITH TIME ZONE
+		);
+
+		CREATE TABLE IF NOT EXISTS gophermart.audit_log (
+		  id BIGSERIAL PRIMARY KEY,
+		  actor VARCHAR(254) NOT NULL,
+		  action VARCHAR(50) NOT NULL,
+		  user_id INT8 REFERENCES gophermart.users (id),
+		  reason TEXT NOT NULL DEFAULT '',
+		  details JSONB,
+		  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+		);
+		CREATE INDEX IF NOT EXISTS idx_audit_log_user_id ON gophermart.audit_log (user_id, created_at, id);
+		CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON gophermart.audit_log (created_at, id);
+
+		ALTER TABLE gophermart.users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'user';
+
+		CREATE TABLE IF NOT EXISTS gophermart.api_keys (
+		  id BIGSERIAL PRIMARY KEY,
+		  user_id INT8 NOT NULL REFERENCES gophermart.users (id),
+		  name VARCHAR(100) NOT NULL,
+		  prefix VARCHAR(16) NOT NULL,
+		  key_hash VARCHAR(64) NOT NULL UNIQUE,
+		  scopes TEXT[] NOT NULL,
+		  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		  expires_at TIMESTAMP WITH TIME ZONE,
+		  last_used_at TIMESTAMP WITH TIME ZONE,
+		  revoked_at TIMESTAMP WITH TIME ZONE
+		);
+		CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON gophermart.api_keys (user_id);
 		
   `
 
@@ -0,0 +1,171 @@
+package pgstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"github.com/zasuchilas/gophermart/pkg/amount"
+	"time"
+)
+
+func addOrderStatusHistory(ctx context.Context, tx *sql.Tx, orderID int64, status string, accrual int64) error {
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO gophermart.order_status_history (order_id, status, accrual) VALUES ($1, $2, $3);",
+		orderID, status, accrual,
+	)
+	return err
+}
+
+// RegisterOrders registers the order numbers in one transaction
+// and returns the upload result (models.OrderUpload...) of every number.
+func (d *PgStorage) RegisterOrders(ctx context.Context, userID int64, orderNums []string) (map[string]string, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctxTm, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	results := make(map[string]string, len(orderNums))
+
+	rows, err := tx.QueryContext(ctxTm,
+		`INSERT INTO gophermart.user_orders (order_num, user_id)
+		SELECT DISTINCT unnest($1::varchar[]), $2
+		ON CONFLICT (order_num) DO NOTHING
+		RETURNING id, order_num, status;`,
+		orderNums, userID)
+	if err != nil {
+		return nil, err
+	}
+	type inserted struct {
+		id     int64
+		status string
+	}
+	added := make([]inserted, 0, len(orderNums))
+	for rows.Next() {
+		var (
+			v        inserted
+			orderNum string
+		)
+		if err = rows.Scan(&v.id, &orderNum, &v.status); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		results[orderNum] = models.OrderUploadAccepted
+		added = append(added, v)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, v := range added {
+		if err = addOrderStatusHistory(ctxTm, tx, v.id, v.status, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	// the numbers which were uploaded before
+	if len(results) < len(orderNums) {
+		rows, err = tx.QueryContext(ctxTm,
+			"SELECT order_num, user_id FROM gophermart.user_orders WHERE order_num = any($1);",
+			orderNums)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var (
+				orderNum    string
+				ownerUserID int64
+			)
+			if err = rows.Scan(&orderNum, &ownerUserID); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if _, ok := results[orderNum]; ok {
+				continue
+			}
+			if ownerUserID == userID {
+				results[orderNum] = models.OrderUploadAlreadyUploaded
+			} else {
+				results[orderNum] = models.OrderUploadConflict
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (d *PgStorage) GetUserOrder(ctx context.Context, userID int64, orderNum string) (*models.OrderDetails, error) {
+
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var (
+		v          models.OrderDetails
+		id         int64
+		accrual    int64
+		uploadedAt time.Time
+	)
+	err := d.db.QueryRowContext(ctxTm,
+		`SELECT id, order_num, status, accrual, `+currencyColumn("user_orders")+`, uploaded_at
+		FROM gophermart.user_orders WHERE order_num = $1 AND user_id = $2`,
+		orderNum, userID).Scan(&id, &v.OrderNum, &v.Status, &accrual, &v.Currency, &uploadedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	v.Accrual = amount.Amount(accrual)
+	v.UploadedAt = uploadedAt.Format(time.RFC3339)
+
+	select {
+	case <-ctxTm.Done():
+		return nil, fmt.Errorf("the operation was canceled")
+	default:
+		rows, er := d.db.QueryContext(ctxTm,
+			`SELECT status, accrual, created_at FROM gophermart.order_status_history WHERE order_id = $1 ORDER BY id`,
+			id)
+		if er != nil {
+			return nil, er
+		}
+		defer rows.Close()
+
+		v.History = make([]*models.OrderStatusChange, 0)
+		for rows.Next() {
+			var (
+				h         models.OrderStatusChange
+				createdAt time.Time
+			)
+			err = rows.Scan(&h.Status, &accrual, &createdAt)
+			if err != nil {
+				return nil, err
+			}
+			h.Accrual = amount.Amount(accrual)
+			h.ChangedAt = createdAt.Format(time.RFC3339)
+			v.History = append(v.History, &h)
+		}
+
+		err = rows.Err()
+		if err != nil {
+			return nil, err
+		}
+
+		return &v, nil
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
 	"github.com/zasuchilas/gophermart/internal/gophermart/models"
 	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"github.com/zasuchilas/gophermart/pkg/amount"
 	"go.uber.org/zap"
 	"time"
 )
@@ -20,6 +21,12 @@ type PgStorage struct {
 	db *sql.DB
 }
 
+// currencyColumn selects the currency of the account owning a row of the table with the user_id column,
+// the column is qualified by the table name (or alias) of the outer query
+func currencyColumn(table string) string {
+	return "(SELECT u.currency FROM gophermart.users u WHERE u.id = " + table + ".user_id)"
+}
+
 func New() *PgStorage {
 	if config.DatabaseURI == "" {
 		logger.Log.Fatal("database connection string is empty")
@@ -49,21 +56,31 @@ func (d *PgStorage) InstanceName() string {
 	return storage.InstancePostgresql
 }
 
-func (d *PgStorage) Register(ctx context.Context, login, pass string) (userID int64, err error) {
+func (d *PgStorage) Register(ctx context.Context, login, pass, currency string) (userID int64, err error) {
 	var id int64
 	err = d.db.QueryRowContext(
 		ctx,
-		"INSERT INTO gophermart.users (login, pass_hash) VALUES($1, $2) ON CONFLICT DO NOTHING RETURNING id",
-		login, pass,
+		"INSERT INTO gophermart.users (login, pass_hash, currency) VALUES($1, $2, $3) ON CONFLICT DO NOTHING RETURNING id",
+		login, pass, currency,
 	).Scan(&id)
 	return id, err
 }
 
+func (d *PgStorage) GetUserCurrency(ctx context.Context, userID int64) (string, error) {
+	var currency string
+	err := d.db.QueryRowContext(ctx,
+		"SELECT currency FROM gophermart.users WHERE id = $1", userID).Scan(&currency)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", storage.ErrNotFound
+	}
+	return currency, err
+}
+
 func (d *PgStorage) GetLoginData(ctx context.Context, login, password string) (*models.LoginData, error) {
 	var v models.LoginData
 	err := d.db.QueryRowContext(ctx,
-		"SELECT id, login, pass_hash FROM gophermart.users WHERE login = $1 AND deleted = false",
-		login).Scan(&v.UserID, &v.Login, &v.PasswordHash)
+		"SELECT id, login, pass_hash, role FROM gophermart.users WHERE login = $1 AND deleted = false",
+		login).Scan(&v.UserID, &v.Login, &v.PasswordHash, &v.Role)
 	return &v, err
 }
 
@@ -87,7 +104,7 @@ func (d *PgStorage) RegisterOrder(ctx context.Context, userID int64, orderNum st
 	defer stmt1.Close()
 
 	stmt2, err := tx.PrepareContext(ctxTm,
-		"INSERT INTO gophermart.user_orders (order_num, user_id) VALUES ($1, $2);")
+		"INSERT INTO gophermart.user_orders (order_num, user_id) VALUES ($1, $2) RETURNING id, status;")
 	if err != nil {
 		logger.Log.Error("preparing insert stmt", zap.Error(err))
 		return err
@@ -116,7 +133,15 @@ func (d *PgStorage) RegisterOrder(ctx context.Context, userID int64, orderNum st
 	case <-ctxTm.Done():
 		return fmt.Errorf("the operation was canceled on insert stmt")
 	default:
-		_, err = stmt2.ExecContext(ctx, orderNum, userID)
+		var (
+			id     int64
+			status string
+		)
+		err = stmt2.QueryRowContext(ctxTm, orderNum, userID).Scan(&id, &status)
+		if err != nil {
+			return err
+		}
+		err = addOrderStatusHistory(ctxTm, tx, id, status, 0)
 		if err != nil {
 			return err
 		}
@@ -130,54 +155,64 @@ func (d *PgStorage) RegisterOrder(ctx context.Context, userID int64, orderNum st
 	return nil
 }
 
-func (d *PgStorage) GetUserOrders(ctx context.Context, userID int64) ([]*models.Order, error) {
+func (d *PgStorage) GetUserOrders(ctx context.Context, userID int64, q *models.ListQuery) ([]*models.Order, string, error) {
 
 	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	stmt, err := d.db.PrepareContext(ctxTm,
-		`SELECT order_num, status, accrual, uploaded_at FROM gophermart.user_orders WHERE user_id = $1 ORDER BY uploaded_at DESC`)
+	query, args := listQuerySQL(
+		`SELECT id, order_num, status, accrual, `+currencyColumn("user_orders")+`, uploaded_at FROM gophermart.user_orders WHERE user_id = $1`,
+		[]any{userID}, q, "uploaded_at", "status")
+	stmt, err := d.db.PrepareContext(ctxTm, query)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer stmt.Close()
 
 	select {
 	case <-ctxTm.Done():
-		return nil, fmt.Errorf("the operation was canceled")
+		return nil, "", fmt.Errorf("the operation was canceled")
 	default:
-		rows, er := stmt.QueryContext(ctxTm, userID)
+		rows, er := stmt.QueryContext(ctxTm, args...)
 		if er != nil {
-			return nil, er
+			return nil, "", er
 		}
 		defer rows.Close()
 
-		orders := make([]*models.Order, 0)
+		var (
+			orders = make([]*models.Order, 0)
+			times  = make([]time.Time, 0)
+			ids    = make([]int64, 0)
+		)
 		for rows.Next() {
 			var (
 				v          models.Order
+				id         int64
 				accrual    int64
 				uploadedAt time.Time
 			)
-			err = rows.Scan(&v.OrderNum, &v.Status, &accrual, &uploadedAt)
+			err = rows.Scan(&id, &v.OrderNum, &v.Status, &accrual, &v.Currency, &uploadedAt)
 			if err != nil {
-				return nil, err
+				return nil, "", err
 			}
-			v.Accrual = money.New(accrual, money.RUB).AsMajorUnits()
+			v.Accrual = amount.Amount(accrual)
 			v.UploadedAt = uploadedAt.Format(time.RFC3339)
 			orders = append(orders, &v)
+			times = append(times, uploadedAt)
+			ids = append(ids, id)
 		}
 
 		err = rows.Err()
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 
 		if len(orders) == 0 {
-			return nil, storage.ErrNotFound
+			return nil, "", storage.ErrNotFound
 		}
 
-		return orders, nil
+		next, count := pageCursor(q, len(orders), times, ids)
+		return orders[:count], next, nil
 	}
 }
 
@@ -187,7 +222,7 @@ func (d *PgStorage) GetUserBalance(ctx context.Context, userID int64) (*models.U
 	defer cancel()
 
 	stmt, err := d.db.PrepareContext(ctxTm,
-		`SELECT balance, withdrawn FROM gophermart.users WHERE id = $1`)
+		`SELECT balance, withdrawn, held, currency FROM gophermart.users WHERE id = $1`)
 	if err != nil {
 		return nil, err
 	}
@@ -198,18 +233,17 @@ func (d *PgStorage) GetUserBalance(ctx context.Context, userID int64) (*models.U
 		return nil, fmt.Errorf("the operation was canceled")
 	default:
 		var (
-			v         models.UserBalance
 			current   int64
 			withdrawn int64
+			held      int64
+			currency  string
 		)
 		er := stmt.QueryRowContext(ctxTm, userID).
-			Scan(&current, &withdrawn)
+			Scan(&current, &withdrawn, &held, &currency)
 		if er != nil {
 			return nil, er
 		}
-		v.Current = money.New(current, money.RUB).AsMajorUnits()
-		v.Withdrawn = money.New(withdrawn, money.RUB).AsMajorUnits()
-		return &v, nil
+		return newUserBalance(current, withdrawn, held, currency), nil
 	}
 }
 
@@ -223,8 +257,10 @@ func (d *PgStorage) WithdrawTransaction(ctx context.Context, userID int64, order
 	}
 	defer tx.Rollback()
 
+	// the user row stays locked until the end of the transaction,
+	// so parallel withdrawals are checked against the actual balance one by one
 	checkStmt, err := tx.PrepareContext(ctxTm,
-		"SELECT balance, withdrawn FROM gophermart.users WHERE id = $1;")
+		"SELECT balance, currency FROM gophermart.users WHERE id = $1 FOR UPDATE;")
 	if err != nil {
 		logger.Log.Error("preparing check stmt", zap.Error(err))
 		return err
@@ -239,31 +275,25 @@ func (d *PgStorage) WithdrawTransaction(ctx context.Context, userID int64, order
 	}
 	defer withdrawalsStmt.Close()
 
-	balanceStmt, err := tx.PrepareContext(ctxTm,
-		"UPDATE gophermart.users SET balance = $1, withdrawn = $2 WHERE id = $3;")
-	if err != nil {
-		logger.Log.Error("preparing balance stmt", zap.Error(err))
-		return err
-	}
-	defer balanceStmt.Close()
-
-	var (
-		balance   int64
-		withdrawn int64
-	)
-
 	select {
 	case <-ctxTm.Done():
 		return fmt.Errorf("the operation was canceled on check stmt")
 	default:
-		err = checkStmt.QueryRowContext(ctxTm, userID).Scan(&balance, &withdrawn)
+		var (
+			balance  int64
+			currency string
+		)
+		err = checkStmt.QueryRowContext(ctxTm, userID).Scan(&balance, &currency)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
 				return fmt.Errorf("user not found (userID %d", userID)
 			}
 			return err
 		}
-		currentBalance := money.New(balance, money.RUB)
+		if sum.Currency().Code != currency {
+			return storage.ErrCurrencyMismatch
+		}
+		currentBalance := money.New(balance, currency)
 		nextBalance, er := currentBalance.Subtract(sum)
 		if er != nil {
 			return fmt.Errorf("error in calculating the new balance (current balance %f, sum %f)",
@@ -272,16 +302,6 @@ func (d *PgStorage) WithdrawTransaction(ctx context.Context, userID int64, order
 		if nextBalance.IsNegative() {
 			return storage.ErrNotEnoughFunds
 		}
-		currentWithdrawn := money.New(withdrawn, money.RUB)
-		nextWithdrawn, er := currentWithdrawn.Add(sum)
-		if er != nil {
-			return fmt.Errorf("error in calculating the new withdrawn (current withdrawn %f, sum %f)",
-				currentWithdrawn.AsMajorUnits(), sum.AsMajorUnits())
-		}
-
-		// new values for db
-		balance = nextBalance.Amount()
-		withdrawn = nextWithdrawn.Amount()
 	}
 
 	select {
@@ -298,11 +318,16 @@ func (d *PgStorage) WithdrawTransaction(ctx context.Context, userID int64, order
 
 	select {
 	case <-ctxTm.Done():
-		return fmt.Errorf("the operation was canceled on balance stmt")
+		return fmt.Errorf("the operation was canceled on ledger entry")
 	default:
-		_, err = balanceStmt.ExecContext(ctx,
-			balance, withdrawn, userID,
-		)
+		_, err = appendLedgerEntry(ctxTm, tx, ledgerEntry{
+			userID:    userID,
+			operation: ledgerOperationWithdrawal,
+			debit:     ledgerAccountUser,
+			credit:    ledgerAccountWithdrawals,
+			amount:    sum.Amount(),
+			orderNum:  orderNum,
+		})
 		if err != nil {
 			return err
 		}
@@ -316,54 +341,58 @@ func (d *PgStorage) WithdrawTransaction(ctx context.Context, userID int64, order
 	return nil
 }
 
-func (d *PgStorage) GetUserWithdrawals(ctx context.Context, userID int64) (models.WithdrawalsData, error) {
+func (d *PgStorage) GetUserWithdrawals(ctx context.Context, userID int64, q *models.ListQuery) (models.WithdrawalsData, string, error) {
 
 	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	stmt, err := d.db.PrepareContext(ctxTm,
-		`SELECT order_num, amount, processed_at FROM gophermart.withdrawals WHERE user_id = $1 ORDER BY processed_at DESC`)
+	query, args := listQuerySQL(
+		`SELECT id, order_num, amount, `+currencyColumn("withdrawals")+`, processed_at, reversed_at, reversed_by, reversal_reason
+		FROM gophermart.withdrawals WHERE user_id = $1`,
+		[]any{userID}, q, "processed_at", "")
+	stmt, err := d.db.PrepareContext(ctxTm, query)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer stmt.Close()
 
 	select {
 	case <-ctxTm.Done():
-		return nil, fmt.Errorf("the operation was canceled")
+		return nil, "", fmt.Errorf("the operation was canceled")
 	default:
-		rows, er := stmt.QueryContext(ctxTm, userID)
+		rows, er := stmt.QueryContext(ctxTm, args...)
 		if er != nil {
-			return nil, er
+			return nil, "", er
 		}
 		defer rows.Close()
 
-		withdrawals := make(models.WithdrawalsData, 0)
+		var (
+			withdrawals = make(models.WithdrawalsData, 0)
+			times       = make([]time.Time, 0)
+			ids         = make([]int64, 0)
+		)
 		for rows.Next() {
-			var (
-				v           models.Withdrawal
-				amount      int64
-				processedAt time.Time
-			)
-			err = rows.Scan(&v.OrderNum, &amount, &processedAt)
+			var r withdrawalRow
+			err = rows.Scan(&r.id, &r.orderNum, &r.amount, &r.currency, &r.processedAt, &r.reversedAt, &r.reversedBy, &r.reversalReason)
 			if err != nil {
-				return nil, err
+				return nil, "", err
 			}
-			v.Sum = money.New(amount, money.RUB).AsMajorUnits()
-			v.ProcessedAt = processedAt.Format(time.RFC3339)
-			withdrawals = append(withdrawals, &v)
+			withdrawals = append(withdrawals, r.toWithdrawal())
+			times = append(times, r.processedAt)
+			ids = append(ids, r.id)
 		}
 
 		err = rows.Err()
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 
 		if len(withdrawals) == 0 {
-			return nil, storage.ErrNotFound
+			return nil, "", storage.ErrNotFound
 		}
 
-		return withdrawals, nil
+		next, count := pageCursor(q, len(withdrawals), times, ids)
+		return withdrawals[:count], next, nil
 	}
 }
 
@@ -379,7 +408,9 @@ func (d *PgStorage) GetOrdersPack(ctx context.Context) ([]*models.OrderRow, erro
 	defer cancel()
 
 	stmt, err := d.db.PrepareContext(ctxTm,
-		`SELECT id, order_num, status, accrual, user_id, uploaded_at FROM gophermart.user_orders WHERE status = any($1) LIMIT $2`)
+		`SELECT id, order_num, status, accrual, `+currencyColumn("user_orders")+`, user_id, uploaded_at
+		FROM gophermart.user_orders WHERE status = any($1)
+		AND user_id IN (SELECT id FROM gophermart.users WHERE deleted = false) LIMIT $2`)
 	if err != nil {
 		return nil, err
 	}
@@ -400,7 +431,7 @@ func (d *PgStorage) GetOrdersPack(ctx context.Context) ([]*models.OrderRow, erro
 			var (
 				gd models.OrderRow
 			)
-			err = rows.Scan(&gd.ID, &gd.OrderNum, &gd.Status, &gd.Accrual, &gd.UserID, &gd.UploadedAt)
+			err = rows.Scan(&gd.ID, &gd.OrderNum, &gd.Status, &gd.Accrual, &gd.Currency, &gd.UserID, &gd.UploadedAt)
 			if err != nil {
 				return nil, err
 			}
@@ -417,6 +448,13 @@ func (d *PgStorage) GetOrdersPack(ctx context.Context) ([]*models.OrderRow, erro
 }
 
 func (d *PgStorage) UpdateOrder(ctx context.Context, userID, id int64, status string, accrual *money.Money) error {
+	// final statuses are never changed again,
+	// so the accrual is credited only once on the transition to PROCESSED
+	finalStatuses := []string{
+		common.OrderStatusProcessed,
+		common.OrderStatusInvalid,
+	}
+
 	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
@@ -426,42 +464,70 @@ func (d *PgStorage) UpdateOrder(ctx context.Context, userID, id int64, status st
 	}
 	defer tx.Rollback()
 
-	orderStmt, err := tx.PrepareContext(ctxTm,
-		"UPDATE gophermart.user_orders SET status = $1, accrual = $2 WHERE id = $3;")
+	// the accrual is credited only in the account currency
+	var currency string
+	err = tx.QueryRowContext(ctxTm, "SELECT currency FROM gophermart.users WHERE id = $1;", userID).Scan(&currency)
 	if err != nil {
-		logger.Log.Info("preparing order stmt", zap.String("error", err.Error()))
 		return err
 	}
-	defer orderStmt.Close()
+	if accrual.Currency().Code != currency {
+		return storage.ErrCurrencyMismatch
+	}
 
-	balanceStmt, err := tx.PrepareContext(ctxTm,
-		"UPDATE gophermart.users SET balance = balance + $1 WHERE id = $2;")
+	orderStmt, err := tx.PrepareContext(ctxTm,
+		`UPDATE gophermart.user_orders SET status = $1, accrual = $2
+		WHERE id = $3 AND user_id = $4 AND status <> all($5) AND (status <> $1 OR accrual <> $2)
+		RETURNING order_num;`)
 	if err != nil {
-		logger.Log.Info("preparing balance stmt", zap.String("error", err.Error()))
+		logger.Log.Info("preparing order stmt", zap.String("error", err.Error()))
 		return err
 	}
-	defer balanceStmt.Close()
+	defer orderStmt.Close()
 
+	var orderNum string
 	select {
 	case <-ctxTm.Done():
 		return fmt.Errorf("the operation was canceled")
 	default:
-		_, err = orderStmt.ExecContext(ctx,
-			status, accrual.Amount(), id,
-		)
+		err = orderStmt.QueryRowContext(ctxTm,
+			status, accrual.Amount(), id, userID, finalStatuses,
+		).Scan(&orderNum)
+		if errors.Is(err, sql.ErrNoRows) {
+			// the order is already in a final status, nothing has changed (or it does not exist)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = addOrderStatusHistory(ctxTm, tx, id, status, accrual.Amount())
+		if err != nil {
+			return err
+		}
+		order := &models.Order{
+			OrderNum: orderNum,
+			Status:   status,
+			Accrual:  amount.Amount(accrual.Amount()),
+			Currency: currency,
+		}
+		err = orderChanged(ctxTm, tx, userID, order)
 		if err != nil {
 			return err
 		}
 	}
 
-	if status == common.OrderStatusProcessed {
+	if status == common.OrderStatusProcessed && accrual.IsPositive() {
 		select {
 		case <-ctxTm.Done():
 			return fmt.Errorf("the operation was canceled")
 		default:
-			_, err = balanceStmt.ExecContext(ctx,
-				accrual.Amount(), userID,
-			)
+			_, err = appendLedgerEntry(ctxTm, tx, ledgerEntry{
+				userID:    userID,
+				operation: ledgerOperationAccrual,
+				debit:     ledgerAccountAccruals,
+				credit:    ledgerAccountUser,
+				amount:    accrual.Amount(),
+				orderNum:  orderNum,
+			})
 			if err != nil {
 				return err
 			}
@@ -0,0 +1,165 @@
+package pgstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/zasuchilas/gophermart/internal/common"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/pkg/amount"
+	"time"
+)
+
+const (
+	ledgerOperationReconciliation = "RECONCILIATION"
+	ledgerAccountReconciliation   = "reconciliation"
+)
+
+// expectedCountersQuery recomputes the balance counters from the source tables:
+// processed accruals of user_orders, the not reversed rows of withdrawals, the active holds
+// and the manual changes (the operator adjustments and the balance forfeited on the account deletion).
+const expectedCountersQuery = `
+	SELECT u.id, u.login, u.currency, u.balance, u.withdrawn, u.held,
+		coalesce(a.total, 0) - coalesce(w.total, 0) - coalesce(h.total, 0) + coalesce(m.total, 0) AS expected_balance,
+		coalesce(w.total, 0) AS expected_withdrawn,
+		coalesce(h.total, 0) AS expected_held
+	FROM gophermart.users u
+	LEFT JOIN (
+		SELECT user_id, sum(accrual) AS total FROM gophermart.user_orders WHERE status = $1 GROUP BY user_id
+	) a ON a.user_id = u.id
+	LEFT JOIN (
+		SELECT user_id, sum(amount) AS total FROM gophermart.withdrawals WHERE reversed_at IS NULL GROUP BY user_id
+	) w ON w.user_id = u.id
+	LEFT JOIN (
+		SELECT user_id, sum(amount) AS total FROM gophermart.holds WHERE status = 'ACTIVE' GROUP BY user_id
+	) h ON h.user_id = u.id
+	LEFT JOIN (
+		SELECT user_id, sum(CASE WHEN credit_account = 'user' THEN amount ELSE -amount END) AS total
+		FROM gophermart.ledger WHERE debit_account IN ('closures', 'adjustments') OR credit_account IN ('closures', 'adjustments')
+		GROUP BY user_id
+	) m ON m.user_id = u.id`
+
+type counters struct {
+	userID            int64
+	login             string
+	currency          string
+	balance           int64
+	withdrawn         int64
+	held              int64
+	expectedBalance   int64
+	expectedWithdrawn int64
+	expectedHeld      int64
+}
+
+func (c *counters) drifted() bool {
+	return c.balance != c.expectedBalance || c.withdrawn != c.expectedWithdrawn || c.held != c.expectedHeld
+}
+
+func (c *counters) toDrift() *models.BalanceDrift {
+	return &models.BalanceDrift{
+		UserID:            c.userID,
+		Login:             c.login,
+		Currency:          c.currency,
+		StoredBalance:     amount.Amount(c.balance),
+		ExpectedBalance:   amount.Amount(c.expectedBalance),
+		StoredWithdrawn:   amount.Amount(c.withdrawn),
+		ExpectedWithdrawn: amount.Amount(c.expectedWithdrawn),
+		StoredHeld:        amount.Amount(c.held),
+		ExpectedHeld:      amount.Amount(c.expectedHeld),
+	}
+}
+
+func (d *PgStorage) FindBalanceDrifts(ctx context.Context) (checked int, drifts []*models.BalanceDrift, err error) {
+	rows, err := d.db.QueryContext(ctx, expectedCountersQuery+" ORDER BY u.id", common.OrderStatusProcessed)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	drifts = make([]*models.BalanceDrift, 0)
+	for rows.Next() {
+		var c counters
+		err = rows.Scan(&c.userID, &c.login, &c.currency, &c.balance, &c.withdrawn, &c.held,
+			&c.expectedBalance, &c.expectedWithdrawn, &c.expectedHeld)
+		if err != nil {
+			return 0, nil, err
+		}
+		checked++
+		if c.drifted() {
+			drifts = append(drifts, c.toDrift())
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return checked, drifts, nil
+}
+
+// RepairBalanceDrift checks the user counters again under the row lock and fixes them
+// with reconciliation ledger entries. It returns nil if the counters are consistent.
+func (d *PgStorage) RepairBalanceDrift(ctx context.Context, userID int64) (*models.BalanceDrift, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctxTm, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctxTm, "SELECT id FROM gophermart.users WHERE id = $1 FOR UPDATE;", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var c counters
+	err = tx.QueryRowContext(ctxTm, expectedCountersQuery+" WHERE u.id = $2", common.OrderStatusProcessed, userID).
+		Scan(&c.userID, &c.login, &c.currency, &c.balance, &c.withdrawn, &c.held, &c.expectedBalance, &c.expectedWithdrawn, &c.expectedHeld)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("user not found (userID %d)", userID)
+		}
+		return nil, err
+	}
+	if !c.drifted() {
+		return nil, nil
+	}
+
+	entries := make([]ledgerEntry, 0, 3)
+	if delta := c.expectedBalance - c.balance; delta > 0 {
+		entries = append(entries, ledgerEntry{debit: ledgerAccountReconciliation, credit: ledgerAccountUser, amount: delta})
+	} else if delta < 0 {
+		entries = append(entries, ledgerEntry{debit: ledgerAccountUser, credit: ledgerAccountReconciliation, amount: -delta})
+	}
+	if delta := c.expectedWithdrawn - c.withdrawn; delta > 0 {
+		entries = append(entries, ledgerEntry{debit: ledgerAccountReconciliation, credit: ledgerAccountWithdrawals, amount: delta})
+	} else if delta < 0 {
+		entries = append(entries, ledgerEntry{debit: ledgerAccountWithdrawals, credit: ledgerAccountReconciliation, amount: -delta})
+	}
+	if delta := c.expectedHeld - c.held; delta > 0 {
+		entries = append(entries, ledgerEntry{debit: ledgerAccountReconciliation, credit: ledgerAccountHolds, amount: delta})
+	} else if delta < 0 {
+		entries = append(entries, ledgerEntry{debit: ledgerAccountHolds, credit: ledgerAccountReconciliation, amount: -delta})
+	}
+
+	for _, e := range entries {
+		e.userID = userID
+		e.operation = ledgerOperationReconciliation
+		if _, err = appendLedgerEntry(ctxTm, tx, e); err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	drift := c.toDrift()
+	drift.Repaired = true
+	return drift, nil
+}
@@ -0,0 +1,149 @@
+package pgstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"time"
+)
+
+// A session is created on login. Only the sha256 hash of its refresh token is stored,
+// the hash is replaced on every refresh. The previous hash is kept to detect the reuse
+// of a stolen refresh token, such a reuse revokes the session.
+
+// CreateSession stores the new session, the expired sessions of the user are removed here as well.
+func (d *PgStorage) CreateSession(ctx context.Context, userID int64, refreshHash string, expiresAt time.Time) (int64, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctxTm,
+		"DELETE FROM gophermart.sessions WHERE user_id = $1 AND expires_at < now();", userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = d.db.QueryRowContext(ctxTm,
+		"INSERT INTO gophermart.sessions (user_id, refresh_hash, expires_at) VALUES ($1, $2, $3) RETURNING id;",
+		userID, refreshHash, expiresAt,
+	).Scan(&id)
+	return id, err
+}
+
+// RefreshSession replaces the refresh token of the session and prolongs it.
+func (d *PgStorage) RefreshSession(ctx context.Context, refreshHash, newRefreshHash string, expiresAt time.Time) (*models.Session, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctxTm, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// the concurrent refreshes with the same token wait here, the second one sees the token rotated
+	var (
+		v          models.Session
+		current    bool
+		revoked    bool
+		validUntil time.Time
+		deleted    bool
+	)
+	err = tx.QueryRowContext(ctxTm,
+		`SELECT s.id, s.user_id, u.role, s.refresh_hash = $1, s.revoked_at IS NOT NULL, s.expires_at, u.deleted
+		FROM gophermart.sessions s JOIN gophermart.users u ON u.id = s.user_id
+		WHERE s.refresh_hash = $1 OR s.previous_hash = $1 FOR UPDATE OF s;`,
+		refreshHash,
+	).Scan(&v.ID, &v.UserID, &v.Role, &current, &revoked, &validUntil, &deleted)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+
+	switch {
+	case revoked:
+		return nil, storage.ErrSessionRevoked
+	case deleted:
+		return nil, storage.ErrGone
+	case !validUntil.After(time.Now()):
+		return nil, storage.ErrNotFound
+	case !current:
+		// the rotated token is used again, someone else may hold it
+		_, err = tx.ExecContext(ctxTm, "UPDATE gophermart.sessions SET revoked_at = now() WHERE id = $1;", v.ID)
+		if err != nil {
+			return nil, err
+		}
+		if err = tx.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, storage.ErrSessionRevoked
+	}
+
+	_, err = tx.ExecContext(ctxTm,
+		`UPDATE gophermart.sessions SET previous_hash = refresh_hash, refresh_hash = $1, expires_at = $2, refreshed_at = now()
+		WHERE id = $3;`,
+		newRefreshHash, expiresAt, v.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &v, nil
+}
+
+// CheckSession returns the current role of the user if the session of the access token is still active
+// and its user is not deleted.
+func (d *PgStorage) CheckSession(ctx context.Context, userID, sessionID int64) (string, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var (
+		active, deleted bool
+		role            string
+	)
+	err := d.db.QueryRowContext(ctxTm,
+		`SELECT s.revoked_at IS NULL AND s.expires_at > now(), u.deleted, u.role
+		FROM gophermart.sessions s JOIN gophermart.users u ON u.id = s.user_id
+		WHERE s.id = $1 AND s.user_id = $2`,
+		sessionID, userID,
+	).Scan(&active, &deleted, &role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", storage.ErrSessionRevoked
+		}
+		return "", err
+	}
+	if deleted {
+		return "", storage.ErrGone
+	}
+	if !active {
+		return "", storage.ErrSessionRevoked
+	}
+	return role, nil
+}
+
+func (d *PgStorage) RevokeSession(ctx context.Context, userID, sessionID int64) error {
+	_, err := d.db.ExecContext(ctx,
+		"UPDATE gophermart.sessions SET revoked_at = now() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;",
+		sessionID, userID)
+	return err
+}
+
+// RevokeUserSessions revokes all the sessions of the user and returns the number of them.
+func (d *PgStorage) RevokeUserSessions(ctx context.Context, userID int64) (int64, error) {
+	res, err := d.db.ExecContext(ctx,
+		"UPDATE gophermart.sessions SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL;",
+		userID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
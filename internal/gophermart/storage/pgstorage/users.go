@@ -0,0 +1,245 @@
+package pgstorage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"github.com/zasuchilas/gophermart/pkg/amount"
+	"time"
+)
+
+// A deleted user keeps the row with deleted = true, the orders and the ledger stay for the accounting.
+// The remaining balance (with the released holds) is forfeited to the closures account.
+
+const (
+	ledgerOperationClosure = "CLOSURE"
+	ledgerAccountClosures  = "closures"
+)
+
+func (d *PgStorage) GetPasswordHash(ctx context.Context, userID int64) (string, error) {
+	var passHash string
+	err := d.db.QueryRowContext(ctx,
+		"SELECT pass_hash FROM gophermart.users WHERE id = $1 AND deleted = false", userID).Scan(&passHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", storage.ErrNotFound
+	}
+	return passHash, err
+}
+
+// UpdatePasswordHash replaces the hash of the same password made with the outdated parameters,
+// the hash changed meanwhile by a password change is kept.
+func (d *PgStorage) UpdatePasswordHash(ctx context.Context, userID int64, oldHash, newHash string) error {
+	_, err := d.db.ExecContext(ctx,
+		"UPDATE gophermart.users SET pass_hash = $1 WHERE id = $2 AND pass_hash = $3;", newHash, userID, oldHash)
+	return err
+}
+
+// ChangePassword sets the new password hash and revokes the sessions of the user except keepSessionID.
+func (d *PgStorage) ChangePassword(ctx context.Context, userID int64, passHash string, keepSessionID int64) error {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctxTm, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctxTm,
+		"UPDATE gophermart.users SET pass_hash = $1 WHERE id = $2 AND deleted = false;", passHash, userID)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return storage.ErrNotFound
+	}
+
+	_, err = tx.ExecContext(ctxTm,
+		"UPDATE gophermart.sessions SET revoked_at = now() WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL;",
+		userID, keepSessionID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CreatePasswordReset stores the hash of the reset token of the user,
+// it returns storage.ErrNotFound for an unknown or deleted login.
+func (d *PgStorage) CreatePasswordReset(ctx context.Context, login, tokenHash string, expiresAt time.Time) error {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	res, err := d.db.ExecContext(ctxTm,
+		`INSERT INTO gophermart.password_resets (user_id, token_hash, expires_at)
+		SELECT id, $2, $3 FROM gophermart.users WHERE login = $1 AND deleted = false;`,
+		login, tokenHash, expiresAt)
+	if err != nil {
+		return err
+	}
+	created, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if created == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// ResetPassword uses the reset token once: it sets the new password hash, voids the other
+// reset tokens and revokes all the sessions of the user.
+// It returns storage.ErrNotFound for an unknown, used or expired token.
+func (d *PgStorage) ResetPassword(ctx context.Context, tokenHash, passHash string) error {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctxTm, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the concurrent confirmations with the same token wait here, the second one sees it used
+	var userID int64
+	err = tx.QueryRowContext(ctxTm,
+		`SELECT r.user_id FROM gophermart.password_resets r JOIN gophermart.users u ON u.id = r.user_id
+		WHERE r.token_hash = $1 AND r.used_at IS NULL AND r.expires_at > now() AND u.deleted = false
+		FOR UPDATE OF r;`,
+		tokenHash,
+	).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.ErrNotFound
+		}
+		return err
+	}
+
+	_, err = tx.ExecContext(ctxTm,
+		"UPDATE gophermart.password_resets SET used_at = now() WHERE user_id = $1 AND used_at IS NULL;", userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctxTm, "UPDATE gophermart.users SET pass_hash = $1 WHERE id = $2;", passHash, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctxTm,
+		"UPDATE gophermart.sessions SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL;", userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteUser marks the user deleted, releases the active holds, forfeits the balance
+// and revokes the sessions. It returns the forfeited balance.
+func (d *PgStorage) DeleteUser(ctx context.Context, userID int64, audit *models.AuditEntry) (*models.AccountClosure, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctxTm, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		deleted  bool
+		currency string
+	)
+	err = tx.QueryRowContext(ctxTm,
+		"SELECT deleted, currency FROM gophermart.users WHERE id = $1 FOR UPDATE;", userID).Scan(&deleted, &currency)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	if deleted {
+		return nil, storage.ErrGone
+	}
+
+	rows, err := tx.QueryContext(ctxTm,
+		"SELECT "+holdColumns+" FROM gophermart.holds WHERE user_id = $1 AND status = $2 FOR UPDATE;",
+		userID, models.HoldStatusActive)
+	if err != nil {
+		return nil, err
+	}
+	active := make([]*holdRow, 0)
+	for rows.Next() {
+		r, er := scanHold(rows)
+		if er != nil {
+			rows.Close()
+			return nil, er
+		}
+		active = append(active, r)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var balance int64
+	err = tx.QueryRowContext(ctxTm, "SELECT balance FROM gophermart.users WHERE id = $1;", userID).Scan(&balance)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range active {
+		if _, err = finishHold(ctxTm, tx, r, models.HoldStatusReleased); err != nil {
+			return nil, err
+		}
+		balance += r.amount
+	}
+
+	if balance > 0 {
+		_, err = appendLedgerEntry(ctxTm, tx, ledgerEntry{
+			userID:    userID,
+			operation: ledgerOperationClosure,
+			debit:     ledgerAccountUser,
+			credit:    ledgerAccountClosures,
+			amount:    balance,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctxTm,
+		"UPDATE gophermart.users SET deleted = true, deleted_at = now() WHERE id = $1;", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	audit.UserID = userID
+	audit.Details, err = json.Marshal(map[string]any{"forfeited": amount.Amount(balance)})
+	if err != nil {
+		return nil, err
+	}
+	if err = addAuditEntry(ctxTm, tx, audit); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctxTm,
+		"UPDATE gophermart.sessions SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL;", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AccountClosure{Forfeited: amount.Amount(balance), Currency: currency}, nil
+}
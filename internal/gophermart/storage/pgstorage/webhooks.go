@@ -0,0 +1,420 @@
+package pgstorage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/zasuchilas/gophermart/internal/common"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"time"
+)
+
+const maxUserWebhooks = 10
+
+// the webhook events of the order statuses, the other statuses are announced by the user events only
+var orderWebhookEvents = map[string]string{
+	common.OrderStatusProcessed: models.WebhookEventOrderProcessed,
+	common.OrderStatusInvalid:   models.WebhookEventOrderInvalid,
+}
+
+// orderChanged announces the changed order status to the user with the event and the webhooks
+func orderChanged(ctx context.Context, tx *sql.Tx, userID int64, order *models.Order) error {
+	err := addEvent(ctx, tx, userID, models.EventTypeOrder, order)
+	if err != nil {
+		return err
+	}
+	if event, ok := orderWebhookEvents[order.Status]; ok {
+		return enqueueWebhookDeliveries(ctx, tx, userID, event, order)
+	}
+	return nil
+}
+
+// enqueueWebhookDeliveries creates the deliveries of the event for every active webhook of the user
+// in the same transaction as the change, the worker sends them later.
+func enqueueWebhookDeliveries(ctx context.Context, tx *sql.Tx, userID int64, event string, order *models.Order) error {
+	payload, err := json.Marshal(&models.WebhookPayload{
+		Event:      event,
+		Order:      order,
+		OccurredAt: time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO gophermart.webhook_deliveries (webhook_id, event, payload)
+		SELECT id, $2, $3 FROM gophermart.webhooks WHERE user_id = $1 AND deleted = false;`,
+		userID, event, string(payload),
+	)
+	return err
+}
+
+func (d *PgStorage) CreateWebhook(ctx context.Context, userID int64, url, secret string) (*models.Webhook, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctxTm, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// serializing the webhook creation of the user to keep the limit
+	_, err = tx.ExecContext(ctxTm, "SELECT id FROM gophermart.users WHERE id = $1 FOR UPDATE;", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int
+	err = tx.QueryRowContext(ctxTm,
+		"SELECT count(*) FROM gophermart.webhooks WHERE user_id = $1 AND deleted = false;", userID).Scan(&count)
+	if err != nil {
+		return nil, err
+	}
+	if count >= maxUserWebhooks {
+		return nil, storage.ErrLimitExceeded
+	}
+
+	var (
+		v         = models.Webhook{URL: url, Secret: secret}
+		createdAt time.Time
+	)
+	err = tx.QueryRowContext(ctxTm,
+		"INSERT INTO gophermart.webhooks (user_id, url, secret) VALUES ($1, $2, $3) RETURNING id, created_at;",
+		userID, url, secret,
+	).Scan(&v.ID, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	v.CreatedAt = createdAt.Format(time.RFC3339)
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &v, nil
+}
+
+func (d *PgStorage) GetUserWebhooks(ctx context.Context, userID int64) ([]*models.Webhook, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctxTm,
+		"SELECT id, url, created_at FROM gophermart.webhooks WHERE user_id = $1 AND deleted = false ORDER BY id",
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := make([]*models.Webhook, 0)
+	for rows.Next() {
+		var (
+			v         models.Webhook
+			createdAt time.Time
+		)
+		err = rows.Scan(&v.ID, &v.URL, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		v.CreatedAt = createdAt.Format(time.RFC3339)
+		webhooks = append(webhooks, &v)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(webhooks) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	return webhooks, nil
+}
+
+func (d *PgStorage) DeleteWebhook(ctx context.Context, userID, id int64) error {
+	res, err := d.db.ExecContext(ctx,
+		"UPDATE gophermart.webhooks SET deleted = true WHERE id = $1 AND user_id = $2 AND deleted = false;",
+		id, userID)
+	if err != nil {
+		return err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (d *PgStorage) GetWebhookDeliveries(ctx context.Context, userID, webhookID int64) ([]*models.WebhookDelivery, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctxTm,
+		`SELECT dl.id, dl.webhook_id, dl.event, dl.payload, dl.status, dl.attempts, dl.last_status_code, dl.last_error,
+			dl.next_attempt_at, dl.created_at, dl.delivered_at
+		FROM gophermart.webhook_deliveries dl
+		JOIN gophermart.webhooks wh ON wh.id = dl.webhook_id
+		WHERE dl.webhook_id = $1 AND wh.user_id = $2 AND wh.deleted = false
+		ORDER BY dl.id DESC LIMIT 100`,
+		webhookID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]*models.WebhookDelivery, 0)
+	for rows.Next() {
+		var (
+			v             models.WebhookDelivery
+			payload       string
+			nextAttemptAt time.Time
+			createdAt     time.Time
+			deliveredAt   sql.NullTime
+		)
+		err = rows.Scan(&v.ID, &v.WebhookID, &v.Event, &payload, &v.Status, &v.Attempts, &v.LastStatusCode,
+			&v.LastError, &nextAttemptAt, &createdAt, &deliveredAt)
+		if err != nil {
+			return nil, err
+		}
+		v.Payload = json.RawMessage(payload)
+		if v.Status == models.WebhookDeliveryPending {
+			v.NextAttemptAt = nextAttemptAt.Format(time.RFC3339)
+		}
+		v.CreatedAt = createdAt.Format(time.RFC3339)
+		if deliveredAt.Valid {
+			v.DeliveredAt = deliveredAt.Time.Format(time.RFC3339)
+		}
+		deliveries = append(deliveries, &v)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(deliveries) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	err = d.getWebhookAttempts(ctxTm, deliveries)
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// getWebhookAttempts fills the attempt logs of the deliveries
+func (d *PgStorage) getWebhookAttempts(ctx context.Context, deliveries []*models.WebhookDelivery) error {
+	byID := make(map[int64]*models.WebhookDelivery, len(deliveries))
+	ids := make([]int64, 0, len(deliveries))
+	for _, v := range deliveries {
+		v.AttemptLog = make([]*models.WebhookAttempt, 0)
+		byID[v.ID] = v
+		ids = append(ids, v.ID)
+	}
+
+	rows, err := d.db.QueryContext(ctx,
+		`SELECT delivery_id, status_code, error, duration_ms, attempted_at
+		FROM gophermart.webhook_attempts
+		WHERE delivery_id = any($1)
+		ORDER BY id DESC`,
+		ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			a           models.WebhookAttempt
+			deliveryID  int64
+			attemptedAt time.Time
+		)
+		err = rows.Scan(&deliveryID, &a.StatusCode, &a.Error, &a.DurationMS, &attemptedAt)
+		if err != nil {
+			return err
+		}
+		a.AttemptedAt = attemptedAt.Format(time.RFC3339)
+		byID[deliveryID].AttemptLog = append(byID[deliveryID].AttemptLog, &a)
+	}
+	return rows.Err()
+}
+
+func (d *PgStorage) RedeliverWebhookDelivery(ctx context.Context, userID, webhookID, deliveryID int64) error {
+	res, err := d.db.ExecContext(ctx,
+		`UPDATE gophermart.webhook_deliveries dl SET status = $1, attempts = 0, next_attempt_at = now()
+		FROM gophermart.webhooks wh
+		WHERE dl.id = $2 AND dl.webhook_id = $3 AND wh.id = dl.webhook_id AND wh.user_id = $4 AND wh.deleted = false;`,
+		models.WebhookDeliveryPending, deliveryID, webhookID, userID)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// ClaimWebhookDeliveries takes the due deliveries and postpones them for the lease,
+// so the other gophermart processes do not send them at the same time.
+func (d *PgStorage) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*models.WebhookDelivery, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctxTm, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctxTm,
+		`SELECT dl.id, dl.webhook_id, dl.event, dl.payload, dl.attempts, wh.url, wh.secret
+		FROM gophermart.webhook_deliveries dl
+		JOIN gophermart.webhooks wh ON wh.id = dl.webhook_id
+		JOIN gophermart.users u ON u.id = wh.user_id
+		WHERE dl.status = $1 AND dl.next_attempt_at <= now() AND wh.deleted = false AND u.deleted = false
+		ORDER BY dl.next_attempt_at
+		LIMIT $2
+		FOR UPDATE OF dl SKIP LOCKED`,
+		models.WebhookDeliveryPending, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]*models.WebhookDelivery, 0)
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var (
+			v       models.WebhookDelivery
+			payload string
+		)
+		err = rows.Scan(&v.ID, &v.WebhookID, &v.Event, &payload, &v.Attempts, &v.URL, &v.Secret)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		v.Payload = json.RawMessage(payload)
+		deliveries = append(deliveries, &v)
+		ids = append(ids, v.ID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		return deliveries, nil
+	}
+
+	_, err = tx.ExecContext(ctxTm,
+		"UPDATE gophermart.webhook_deliveries SET next_attempt_at = $1 WHERE id = any($2);",
+		time.Now().Add(lease), ids)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// FinishWebhookDelivery records the attempt in the attempt log and updates the delivery state.
+func (d *PgStorage) FinishWebhookDelivery(ctx context.Context, id int64, status string, statusCode int, lastError string,
+	duration time.Duration, nextAttemptAt time.Time) error {
+	var deliveredAt sql.NullTime
+	if status == models.WebhookDeliveryDelivered {
+		deliveredAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+	if nextAttemptAt.IsZero() {
+		nextAttemptAt = time.Now()
+	}
+
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctxTm, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctxTm,
+		`UPDATE gophermart.webhook_deliveries
+		SET status = $1, attempts = attempts + 1, last_status_code = $2, last_error = $3, next_attempt_at = $4, delivered_at = $5
+		WHERE id = $6;`,
+		status, statusCode, lastError, nextAttemptAt, deliveredAt, id)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return fmt.Errorf("webhook delivery not found (id %d)", id)
+	}
+
+	_, err = tx.ExecContext(ctxTm,
+		`INSERT INTO gophermart.webhook_attempts (delivery_id, status_code, error, duration_ms)
+		VALUES ($1, $2, $3, $4);`,
+		id, statusCode, lastError, duration.Milliseconds())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteWebhookDeliveriesBefore deletes the delivered and failed deliveries created before the time
+// with their attempt logs, the pending deliveries are kept.
+func (d *PgStorage) DeleteWebhookDeliveriesBefore(ctx context.Context, before time.Time) (int64, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// the attempts go first, they refer to the deliveries
+	_, err = tx.ExecContext(ctx,
+		`DELETE FROM gophermart.webhook_attempts
+		WHERE delivery_id IN (
+		  SELECT id FROM gophermart.webhook_deliveries
+		  WHERE status IN ($1, $2) AND created_at < $3
+		);`,
+		models.WebhookDeliveryDelivered, models.WebhookDeliveryFailed, before)
+	if err != nil {
+		return 0, err
+	}
+
+	// a delivery redelivered in the meantime is pending again and keeps its new attempts
+	res, err := tx.ExecContext(ctx,
+		`DELETE FROM gophermart.webhook_deliveries wd
+		WHERE wd.status IN ($1, $2) AND wd.created_at < $3
+		  AND NOT EXISTS (SELECT 1 FROM gophermart.webhook_attempts wa WHERE wa.delivery_id = wd.id);`,
+		models.WebhookDeliveryDelivered, models.WebhookDeliveryFailed, before)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, tx.Commit()
+}
@@ -0,0 +1,129 @@
+package pgstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"github.com/zasuchilas/gophermart/pkg/amount"
+	"time"
+)
+
+// A reversed withdrawal is kept with the reversal mark,
+// the amount returns to the balance with the REVERSAL ledger entry.
+
+type withdrawalRow struct {
+	id             int64
+	userID         int64
+	orderNum       string
+	amount         int64
+	currency       string
+	processedAt    time.Time
+	reversedAt     sql.NullTime
+	reversedBy     string
+	reversalReason string
+}
+
+func (r *withdrawalRow) toWithdrawal() *models.Withdrawal {
+	v := &models.Withdrawal{
+		ID:          r.id,
+		OrderNum:    r.orderNum,
+		Sum:         amount.Amount(r.amount),
+		Currency:    r.currency,
+		ProcessedAt: r.processedAt.Format(time.RFC3339),
+		Status:      models.WithdrawalStatusDone,
+	}
+	if r.reversedAt.Valid {
+		v.Status = models.WithdrawalStatusReversed
+		v.ReversedAt = r.reversedAt.Time.Format(time.RFC3339)
+		v.ReversedBy = r.reversedBy
+		v.ReversalReason = r.reversalReason
+	}
+	return v
+}
+
+// CancelWithdrawal reverses the withdrawal of the user if it was made within the window.
+// The withdrawals of other users are not found.
+func (d *PgStorage) CancelWithdrawal(ctx context.Context, userID, id int64, window time.Duration) (*models.Withdrawal, error) {
+	return d.reverseWithdrawal(ctx, id, func(r *withdrawalRow) error {
+		if r.userID != userID {
+			return storage.ErrNotFound
+		}
+		if time.Since(r.processedAt) > window {
+			return storage.ErrWindowClosed
+		}
+		r.reversedBy = models.WithdrawalReversedByUser
+		r.reversalReason = "canceled by the user"
+		return nil
+	})
+}
+
+// ReverseWithdrawal reverses any withdrawal regardless of its age.
+func (d *PgStorage) ReverseWithdrawal(ctx context.Context, id int64, reason string) (*models.Withdrawal, error) {
+	return d.reverseWithdrawal(ctx, id, func(r *withdrawalRow) error {
+		r.reversedBy = models.WithdrawalReversedByAdmin
+		r.reversalReason = reason
+		return nil
+	})
+}
+
+func (d *PgStorage) reverseWithdrawal(ctx context.Context, id int64, check func(r *withdrawalRow) error) (*models.Withdrawal, error) {
+	ctxTm, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctxTm, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// the row lock makes the concurrent cancellations of the same withdrawal wait,
+	// the second one sees it reversed
+	var r withdrawalRow
+	err = tx.QueryRowContext(ctxTm,
+		`SELECT id, user_id, order_num, amount, `+currencyColumn("withdrawals")+`, processed_at, reversed_at FROM gophermart.withdrawals
+		WHERE id = $1 FOR UPDATE;`, id,
+	).Scan(&r.id, &r.userID, &r.orderNum, &r.amount, &r.currency, &r.processedAt, &r.reversedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+
+	if err = check(&r); err != nil {
+		return nil, err
+	}
+	if r.reversedAt.Valid {
+		return nil, storage.ErrReversed
+	}
+
+	err = tx.QueryRowContext(ctxTm,
+		`UPDATE gophermart.withdrawals SET reversed_at = now(), reversed_by = $1, reversal_reason = $2
+		WHERE id = $3 RETURNING reversed_at;`,
+		r.reversedBy, r.reversalReason, r.id,
+	).Scan(&r.reversedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = appendLedgerEntry(ctxTm, tx, ledgerEntry{
+		userID:    r.userID,
+		operation: ledgerOperationReversal,
+		debit:     ledgerAccountWithdrawals,
+		credit:    ledgerAccountUser,
+		amount:    r.amount,
+		orderNum:  r.orderNum,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.toWithdrawal(), nil
+}
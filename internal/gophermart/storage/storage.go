@@ -5,6 +5,7 @@ import (
 	"errors"
 	"github.com/Rhymond/go-money"
 	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"time"
 )
 
 const (
@@ -19,20 +20,103 @@ var (
 	ErrNumberDone     = errors.New("number already done by current user")
 	ErrNumberAdded    = errors.New("number already added by another user")
 	ErrNotEnoughFunds = errors.New("not enough funds on the balance")
+	ErrLimitExceeded  = errors.New("limit exceeded")
+	ErrReversed       = errors.New("withdrawal already reversed")
+	ErrWindowClosed   = errors.New("cancel window is closed")
+	ErrHoldNotActive  = errors.New("hold is not active")
+
+	ErrCurrencyMismatch = errors.New("currency does not match the account currency")
+	ErrSessionRevoked   = errors.New("session is revoked")
+	ErrOrderProcessed   = errors.New("order is already processed")
+	ErrNotDeleted       = errors.New("user is not deleted")
+
+	ErrIdempotencyKeyReused     = errors.New("idempotency key already used for another request")
+	ErrIdempotencyKeyInProgress = errors.New("request with the idempotency key is in progress")
 )
 
+// LoginKey and IPKey are the keys of the failed login counters
+func LoginKey(login string) string {
+	return "login:" + login
+}
+
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
 type Storage interface {
 	Stop()
 	InstanceName() string
 
-	Register(ctx context.Context, login, passHash string) (int64, error)
+	Register(ctx context.Context, login, passHash, currency string) (int64, error)
+	GetUserCurrency(ctx context.Context, userID int64) (string, error)
+	GetPasswordHash(ctx context.Context, userID int64) (string, error)
+	UpdatePasswordHash(ctx context.Context, userID int64, oldHash, newHash string) error
+	ChangePassword(ctx context.Context, userID int64, passHash string, keepSessionID int64) error
+	CreatePasswordReset(ctx context.Context, login, tokenHash string, expiresAt time.Time) error
+	ResetPassword(ctx context.Context, tokenHash, passHash string) error
+	DeleteUser(ctx context.Context, userID int64, audit *models.AuditEntry) (*models.AccountClosure, error)
+
+	CreateSession(ctx context.Context, userID int64, refreshHash string, expiresAt time.Time) (int64, error)
+	RefreshSession(ctx context.Context, refreshHash, newRefreshHash string, expiresAt time.Time) (*models.Session, error)
+	CheckSession(ctx context.Context, userID, sessionID int64) (role string, err error)
+	RevokeSession(ctx context.Context, userID, sessionID int64) error
+	RevokeUserSessions(ctx context.Context, userID int64) (int64, error)
+
+	CreateAPIKey(ctx context.Context, key *models.APIKey, keyHash string, expiresAt time.Time) (*models.APIKey, error)
+	GetUserAPIKeys(ctx context.Context, userID int64) ([]*models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID, id int64) error
+	CheckAPIKey(ctx context.Context, keyHash string) (*models.APIKey, error)
+
 	GetLoginData(ctx context.Context, login, password string) (*models.LoginData, error)
+	AddLoginAttempt(ctx context.Context, key string, window time.Duration, delays []time.Duration) (*models.LoginAttempt, error)
+	ForgiveLoginAttempt(ctx context.Context, key string, delays []time.Duration) error
+	ResetLoginFailures(ctx context.Context, keys ...string) (int64, error)
 	RegisterOrder(ctx context.Context, userID int64, orderNum string) error
-	GetUserOrders(ctx context.Context, userID int64) ([]*models.Order, error)
+	RegisterOrders(ctx context.Context, userID int64, orderNums []string) (map[string]string, error)
+	GetUserOrders(ctx context.Context, userID int64, q *models.ListQuery) (orders []*models.Order, nextCursor string, err error)
+	GetUserOrder(ctx context.Context, userID int64, orderNum string) (*models.OrderDetails, error)
 	GetUserBalance(ctx context.Context, userID int64) (*models.UserBalance, error)
 	WithdrawTransaction(ctx context.Context, userID int64, orderNum string, sum *money.Money) error
-	GetUserWithdrawals(ctx context.Context, userID int64) (models.WithdrawalsData, error)
+	GetUserWithdrawals(ctx context.Context, userID int64, q *models.ListQuery) (withdrawals models.WithdrawalsData, nextCursor string, err error)
+	CancelWithdrawal(ctx context.Context, userID, id int64, window time.Duration) (*models.Withdrawal, error)
+	ReverseWithdrawal(ctx context.Context, id int64, reason string) (*models.Withdrawal, error)
+	CreateHold(ctx context.Context, userID int64, orderNum string, sum *money.Money, expiresAt time.Time) (*models.Hold, error)
+	GetUserHolds(ctx context.Context, userID int64) ([]*models.Hold, error)
+	CaptureHold(ctx context.Context, userID, id int64) (*models.Withdrawal, error)
+	ReleaseHold(ctx context.Context, userID, id int64) (*models.Hold, error)
+	ExpireHolds(ctx context.Context, limit int) (int, error)
+	GetUserLedger(ctx context.Context, userID int64) ([]*models.LedgerEntry, error)
+
+	StartIdempotentRequest(ctx context.Context, userID int64, key, requestHash string, retention time.Duration) (*models.IdempotentResponse, error)
+	SaveIdempotentResponse(ctx context.Context, userID int64, key string, resp *models.IdempotentResponse) error
+	DeleteIdempotencyKey(ctx context.Context, userID int64, key string) error
+
+	GetUserEvents(ctx context.Context, userID, afterID int64) ([]*models.Event, error)
+	GetEvents(ctx context.Context, afterID int64) ([]*models.Event, error)
+	DeleteEventsBefore(ctx context.Context, before time.Time) (int64, error)
+	ListenEvents(ctx context.Context, handler func(*models.Event)) error
+
+	CreateWebhook(ctx context.Context, userID int64, url, secret string) (*models.Webhook, error)
+	GetUserWebhooks(ctx context.Context, userID int64) ([]*models.Webhook, error)
+	DeleteWebhook(ctx context.Context, userID, id int64) error
+	GetWebhookDeliveries(ctx context.Context, userID, webhookID int64) ([]*models.WebhookDelivery, error)
+	RedeliverWebhookDelivery(ctx context.Context, userID, webhookID, deliveryID int64) error
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*models.WebhookDelivery, error)
+	FinishWebhookDelivery(ctx context.Context, id int64, status string, statusCode int, lastError string, duration time.Duration, nextAttemptAt time.Time) error
+	DeleteWebhookDeliveriesBefore(ctx context.Context, before time.Time) (int64, error)
 
 	GetOrdersPack(ctx context.Context) ([]*models.OrderRow, error)
 	UpdateOrder(ctx context.Context, userID, id int64, status string, accrual *money.Money) error
+
+	GetUsers(ctx context.Context, search string, q *models.ListQuery) (users []*models.AdminUser, nextCursor string, err error)
+	GetUser(ctx context.Context, userID int64) (*models.AdminUser, error)
+	SetUserRole(ctx context.Context, login, role string) (int64, error)
+	AdjustBalance(ctx context.Context, userID int64, sum *money.Money, audit *models.AuditEntry) (*models.UserBalance, error)
+	RepollOrder(ctx context.Context, orderNum string, audit *models.AuditEntry) (*models.Order, error)
+	RestoreUser(ctx context.Context, userID int64, audit *models.AuditEntry) (*models.AdminUser, error)
+	AddAuditEntry(ctx context.Context, audit *models.AuditEntry) error
+	GetAuditLog(ctx context.Context, userID int64, q *models.ListQuery) (entries []*models.AuditEntry, nextCursor string, err error)
+
+	FindBalanceDrifts(ctx context.Context) (checked int, drifts []*models.BalanceDrift, err error)
+	RepairBalanceDrift(ctx context.Context, userID int64) (*models.BalanceDrift, error)
 }
@@ -0,0 +1,173 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// The receivers verify a delivery by computing
+// HMAC-SHA256(secret, timestamp + "." + body) and comparing it with the signature header.
+const (
+	HeaderEvent     = "X-Gophermart-Event"
+	HeaderDelivery  = "X-Gophermart-Delivery"
+	HeaderTimestamp = "X-Gophermart-Timestamp"
+	HeaderSignature = "X-Gophermart-Signature"
+
+	signaturePrefix = "sha256="
+	requestTimeout  = 10 * time.Second
+)
+
+var ErrPrivateAddress = errors.New("webhook address is not public")
+
+// the special purpose ranges which are not checked by the netip.Addr methods
+// https://www.iana.org/assignments/iana-ipv4-special-registry
+// https://www.iana.org/assignments/iana-ipv6-special-registry
+var deniedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // this network
+	netip.MustParsePrefix("100.64.0.0/10"),   // shared address space (CGNAT)
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // TEST-NET-1
+	netip.MustParsePrefix("192.88.99.0/24"),  // 6to4 relay anycast
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // TEST-NET-2
+	netip.MustParsePrefix("203.0.113.0/24"),  // TEST-NET-3
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved, including the broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),    // NAT64, would reach any IPv4 address
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // local NAT64
+	netip.MustParsePrefix("100::/64"),        // discard only
+	netip.MustParsePrefix("2001::/23"),       // IETF protocol assignments, including Teredo
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+	netip.MustParsePrefix("2002::/16"),       // 6to4, would reach any IPv4 address
+	netip.MustParsePrefix("3fff::/20"),       // documentation
+	netip.MustParsePrefix("5f00::/16"),       // segment routing
+}
+
+// publicAddr reports whether the address is a public unicast one.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap() // ::ffff:10.0.0.1 is 10.0.0.1
+	if !addr.IsValid() || !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		// IsGlobalUnicast is false for the unspecified, loopback, link-local and multicast addresses
+		return false
+	}
+	for _, prefix := range deniedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+func Verify(secret, timestamp, signature string, body []byte) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// ValidateURL checks the webhook address given by a user.
+func ValidateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("the url scheme must be http or https")
+	}
+	if u.Host == "" {
+		return errors.New("the url host is required")
+	}
+	if u.User != nil {
+		return errors.New("the url must not contain credentials")
+	}
+	return nil
+}
+
+// NewClient returns the client for the webhook deliveries.
+// Unless allowPrivate is set, the connections to loopback, private, link-local, CGNAT
+// and other not public addresses are refused (the addresses are checked after resolving).
+// The environment proxy is not used, the check would see the address of the proxy instead of the receiver.
+func NewClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: requestTimeout}
+	if !allowPrivate {
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicAddr(addrPort.Addr()) {
+				return ErrPrivateAddress
+			}
+			return nil
+		}
+	}
+
+	return &http.Client{
+		Timeout: requestTimeout,
+		Transport: &http.Transport{
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: requestTimeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Deliver posts the signed payload, any 2xx answer means the delivery is done.
+func Deliver(ctx context.Context, client *http.Client, d *models.WebhookDelivery) (statusCode int, err error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gophermart-webhook")
+	req.Header.Set(HeaderEvent, d.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(d.Secret, timestamp, d.Payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,67 @@
+package worker
+
+import (
+	"context"
+	"github.com/zasuchilas/gophermart/internal/gophermart/config"
+	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const holdExpiryPackLimit = 100
+
+// HoldExpiryWorker returns the amounts of the expired holds to the user balances.
+type HoldExpiryWorker struct {
+	waitGroup *sync.WaitGroup
+	store     storage.Storage
+	timer     *time.Timer
+	doneCh    chan struct{}
+}
+
+func NewHoldExpiryWorker(store storage.Storage, wg *sync.WaitGroup) *HoldExpiryWorker {
+	return &HoldExpiryWorker{
+		store:     store,
+		timer:     time.NewTimer(config.HoldExpiryPeriod),
+		doneCh:    make(chan struct{}),
+		waitGroup: wg,
+	}
+}
+
+func (w *HoldExpiryWorker) Start() {
+loop:
+	for {
+		select {
+		case <-w.doneCh:
+			// time to close
+			break loop
+		case <-w.timer.C:
+			// time to work
+			for {
+				expired, err := w.store.ExpireHolds(context.TODO(), holdExpiryPackLimit)
+				if err != nil {
+					logger.Log.Info("error expiring holds in db", zap.String("error", err.Error()))
+					break
+				}
+				if expired > 0 {
+					logger.Log.Debug("holds expired", zap.Int("count", expired))
+				}
+				if expired < holdExpiryPackLimit {
+					break
+				}
+			}
+			w.resetTimer()
+		}
+	}
+}
+
+func (w *HoldExpiryWorker) Stop() {
+	w.timer.Stop()
+	w.doneCh <- struct{}{}
+	w.waitGroup.Done()
+}
+
+func (w *HoldExpiryWorker) resetTimer() {
+	w.timer.Reset(config.HoldExpiryPeriod)
+}
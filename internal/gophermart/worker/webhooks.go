@@ -0,0 +1,143 @@
+package worker
+
+import (
+	"context"
+	"github.com/zasuchilas/gophermart/internal/gophermart/config"
+	"github.com/zasuchilas/gophermart/internal/gophermart/logger"
+	"github.com/zasuchilas/gophermart/internal/gophermart/models"
+	"github.com/zasuchilas/gophermart/internal/gophermart/storage"
+	"github.com/zasuchilas/gophermart/internal/gophermart/webhook"
+	"go.uber.org/zap"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	webhookPackLimit   = 25
+	webhookPoolSize    = 5
+	webhookLease       = time.Minute
+	webhookBackoffBase = 10 * time.Second
+	webhookBackoffMax  = time.Hour
+	webhookPrunePeriod = time.Hour
+)
+
+type WebhookDeliveryWorker struct {
+	waitGroup *sync.WaitGroup
+	store     storage.Storage
+	client    *http.Client
+	timer     *time.Timer
+	pruner    *time.Ticker
+	doneCh    chan struct{}
+}
+
+func NewWebhookDeliveryWorker(store storage.Storage, wg *sync.WaitGroup) *WebhookDeliveryWorker {
+	return &WebhookDeliveryWorker{
+		store:     store,
+		client:    webhook.NewClient(config.WebhookAllowPrivate),
+		timer:     time.NewTimer(config.WebhookPeriod),
+		pruner:    time.NewTicker(webhookPrunePeriod),
+		doneCh:    make(chan struct{}),
+		waitGroup: wg,
+	}
+}
+
+func (w *WebhookDeliveryWorker) Start() {
+loop:
+	for {
+		select {
+		case <-w.doneCh:
+			// time to close
+			break loop
+		case <-w.pruner.C:
+			// time to forget the finished deliveries
+			w.prune()
+		case <-w.timer.C:
+			// time to work
+			deliveries, err := w.store.ClaimWebhookDeliveries(context.TODO(), webhookPackLimit, webhookLease)
+			if err != nil {
+				logger.Log.Info("error getting webhook deliveries from db", zap.String("error", err.Error()))
+				w.resetTimer()
+				continue
+			}
+
+			jobs := make(chan *models.WebhookDelivery, len(deliveries))
+			for _, d := range deliveries {
+				jobs <- d
+			}
+			close(jobs)
+
+			var wg sync.WaitGroup
+			for i := 0; i < webhookPoolSize; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					w.workerProc(jobs)
+				}()
+			}
+			wg.Wait()
+
+			w.resetTimer()
+		}
+	}
+}
+
+func (w *WebhookDeliveryWorker) Stop() {
+	w.timer.Stop()
+	w.pruner.Stop()
+	w.doneCh <- struct{}{}
+	w.waitGroup.Done()
+}
+
+func (w *WebhookDeliveryWorker) resetTimer() {
+	w.timer.Reset(config.WebhookPeriod)
+}
+
+func (w *WebhookDeliveryWorker) prune() {
+	deleted, err := w.store.DeleteWebhookDeliveriesBefore(context.TODO(), time.Now().Add(-config.WebhookRetention))
+	if err != nil {
+		logger.Log.Info("error deleting old webhook deliveries", zap.String("error", err.Error()))
+		return
+	}
+	logger.Log.Debug("old webhook deliveries deleted", zap.Int64("count", deleted))
+}
+
+func (w *WebhookDeliveryWorker) workerProc(jobs <-chan *models.WebhookDelivery) {
+	for d := range jobs {
+		started := time.Now()
+		statusCode, err := webhook.Deliver(context.TODO(), w.client, d)
+		duration := time.Since(started)
+
+		var (
+			status        = models.WebhookDeliveryDelivered
+			lastError     string
+			nextAttemptAt time.Time
+		)
+		if err != nil {
+			lastError = err.Error()
+			attempts := d.Attempts + 1
+			if attempts >= config.WebhookMaxAttempts {
+				status = models.WebhookDeliveryFailed
+			} else {
+				status = models.WebhookDeliveryPending
+				nextAttemptAt = time.Now().Add(backoff(attempts))
+			}
+			logger.Log.Debug("webhook delivery failed",
+				zap.Int64("delivery_id", d.ID), zap.Int("attempts", attempts), zap.String("error", lastError))
+		}
+
+		err = w.store.FinishWebhookDelivery(context.TODO(), d.ID, status, statusCode, lastError, duration, nextAttemptAt)
+		if err != nil {
+			logger.Log.Info("error updating webhook delivery in db", zap.String("error", err.Error()))
+		}
+	}
+}
+
+// backoff doubles the pause after every failed attempt
+func backoff(attempts int) time.Duration {
+	pause := webhookBackoffBase
+	for i := 1; i < attempts && pause < webhookBackoffMax; i++ {
+		pause *= 2
+	}
+	return min(pause, webhookBackoffMax)
+}
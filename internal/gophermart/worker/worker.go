@@ -145,10 +145,23 @@ func (w *OrderEnrichWorker) workerProc(jobs <-chan *models.OrderRow) {
 		}
 
 		// write result into db
+		// (the status may be outdated, repeated updates are ignored by the storage)
 		if order.Status == resp.Status {
 			continue
 		}
-		err = w.store.UpdateOrder(context.TODO(), order.UserID, order.ID, resp.Status, money.NewFromFloat(resp.Accrual, common.Currency))
+		currency := resp.Currency
+		if currency == "" {
+			currency = common.DefaultCurrency
+		}
+		if resp.Status == common.OrderStatusProcessed && currency != order.Currency {
+			// the accrual in another currency is not converted and the order is not failed,
+			// it stays in its status and is polled again until the accrual is corrected
+			logger.Log.Error("the accrual currency does not match the account currency",
+				zap.String("order_num", order.OrderNum),
+				zap.String("currency", currency), zap.String("account_currency", order.Currency))
+			continue
+		}
+		err = w.store.UpdateOrder(context.TODO(), order.UserID, order.ID, resp.Status, money.New(resp.Accrual.Minor(), currency))
 		if err != nil {
 			logger.Log.Info("error updating order data in db", zap.String("error", err.Error()))
 			continue
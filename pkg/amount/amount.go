@@ -0,0 +1,196 @@
+// Package amount is the exact money amount of the JSON API.
+// The amount is kept in minor units (hundredths), so there is no float rounding anywhere:
+// the JSON input is parsed from its decimal text and the output is written as a decimal text.
+package amount
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+)
+
+// Scale is the number of the minor units in the major one.
+const Scale = 100
+
+var (
+	ErrSyntax    = errors.New("the amount must be a decimal number")
+	ErrPrecision = errors.New("the amount must not have more than 2 fractional digits")
+	ErrRange     = errors.New("the amount is out of range")
+)
+
+// the JSON number grammar, fractions like 1/3 accepted by big.Rat are not allowed,
+// the groups are the fractional digits and the exponent
+var decimalRe = regexp.MustCompile(`^-?(?:0|[1-9][0-9]*)(?:\.([0-9]+))?(?:[eE]([+-]?[0-9]+))?$`)
+
+// maxExponent limits the exponent before big.Rat expands it, "1e1000000000" would take gigabytes
+const maxExponent = 20
+
+// IsInvalid reports whether the error is the rejected amount, the JSON decoding errors included.
+func IsInvalid(err error) bool {
+	return errors.Is(err, ErrSyntax) || errors.Is(err, ErrPrecision) || errors.Is(err, ErrRange)
+}
+
+// Amount is the number of the minor units.
+type Amount int64
+
+// Parse parses the decimal text. The fractional digits are counted in the text,
+// so "1.230" is rejected like "1.234", and the exponent is limited to ±20.
+func Parse(s string) (Amount, error) {
+	m := decimalRe.FindStringSubmatch(s)
+	if m == nil {
+		return 0, ErrSyntax
+	}
+	if len(m[1]) > 2 {
+		return 0, ErrPrecision
+	}
+	if m[2] != "" {
+		if exp, err := strconv.Atoi(m[2]); err != nil || exp > maxExponent || exp < -maxExponent {
+			return 0, ErrRange
+		}
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, ErrSyntax
+	}
+	r.Mul(r, big.NewRat(Scale, 1))
+	if !r.IsInt() {
+		return 0, ErrPrecision
+	}
+	n := r.Num()
+	if !n.IsInt64() {
+		return 0, ErrRange
+	}
+	return Amount(n.Int64()), nil
+}
+
+// MustParse is Parse for the constants, it panics on the error.
+func MustParse(s string) Amount {
+	a, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// Minor returns the number of the minor units.
+func (a Amount) Minor() int64 {
+	return int64(a)
+}
+
+// String returns the decimal text with two fractional digits, for example 729.98 or 500.00.
+func (a Amount) String() string {
+	b := make([]byte, 0, 24)
+	u := uint64(a)
+	if a < 0 {
+		b = append(b, '-')
+		u = uint64(-(a + 1)) + 1 // math.MinInt64 has no positive pair
+	}
+	b = strconv.AppendUint(b, u/Scale, 10)
+	b = append(b, '.', byte('0'+u%Scale/10), byte('0'+u%10))
+	return string(b)
+}
+
+// MarshalJSON writes the amount as a JSON number with two fractional digits,
+// QuoteDecimals turns it into the JSON string for the clients asking for strings.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a JSON string with a decimal number.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	s := string(data)
+	if len(data) > 1 && data[0] == '"' {
+		unquoted, err := strconv.Unquote(s)
+		if err != nil {
+			return ErrSyntax
+		}
+		s = unquoted
+	}
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+// MulPercent returns the amount multiplied by the percent (in the amount units, 7.50 is 7.5%),
+// rounded half away from zero to the minor unit.
+func (a Amount) MulPercent(percent Amount) (Amount, error) {
+	p := new(big.Int).Mul(big.NewInt(int64(a)), big.NewInt(int64(percent)))
+	r := new(big.Rat).SetFrac(p, big.NewInt(100*Scale))
+	n := roundHalfAway(r)
+	if !n.IsInt64() || n.Int64() == math.MinInt64 {
+		return 0, ErrRange
+	}
+	return Amount(n.Int64()), nil
+}
+
+func roundHalfAway(r *big.Rat) *big.Int {
+	num := new(big.Int).Abs(r.Num())
+	den := r.Denom()
+	q, m := new(big.Int).QuoRem(num, den, new(big.Int))
+	if new(big.Int).Mul(m, big.NewInt(2)).Cmp(den) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if r.Sign() < 0 {
+		q.Neg(q)
+	}
+	return q
+}
+
+// QuoteDecimals quotes every JSON number with a fractional part in the JSON text.
+// The amounts are the only fractional numbers of the API (MarshalJSON always writes the fraction),
+// so the result is the same document with the amounts as JSON strings.
+func QuoteDecimals(data []byte) []byte {
+	var (
+		out      = make([]byte, 0, len(data)+16)
+		inString bool
+		escaped  bool
+	)
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if inString {
+			out = append(out, c)
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		if c == '"' {
+			inString = true
+			out = append(out, c)
+			continue
+		}
+		if c != '-' && (c < '0' || c > '9') {
+			out = append(out, c)
+			continue
+		}
+
+		end := i
+		for end < len(data) && bytes.IndexByte([]byte("+-.0123456789eE"), data[end]) >= 0 {
+			end++
+		}
+		number := data[i:end]
+		if bytes.IndexByte(number, '.') >= 0 {
+			out = append(out, '"')
+			out = append(out, number...)
+			out = append(out, '"')
+		} else {
+			out = append(out, number...)
+		}
+		i = end - 1
+	}
+	return out
+}
@@ -0,0 +1,111 @@
+package amount
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Amount
+		err  error
+	}{
+		{in: "0", want: 0},
+		{in: "729.98", want: 72998},
+		{in: "0.1", want: 10},
+		{in: "-5.05", want: -505},
+		{in: "1e2", want: 10000},
+		{in: "12.5E-1", want: 125},
+		{in: "1.234", err: ErrPrecision},
+		{in: "0.001", err: ErrPrecision},
+		{in: "1.230", err: ErrPrecision},
+		{in: "1.00e1", want: 1000},
+		{in: "100e-2", want: 100},
+		{in: "1e-3", err: ErrPrecision},
+		{in: "1/3", err: ErrSyntax},
+		{in: "01", err: ErrSyntax},
+		{in: "1.", err: ErrSyntax},
+		{in: "", err: ErrSyntax},
+		{in: "NaN", err: ErrSyntax},
+		{in: "1e30", err: ErrRange},
+		{in: "1e1000000000", err: ErrRange},
+		{in: "1e-1000000000", err: ErrRange},
+		{in: "1e99999999999999999999", err: ErrRange},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("Parse(%q) = %d, %v; want %d, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	for a, want := range map[Amount]string{
+		0:        "0.00",
+		5:        "0.05",
+		72998:    "729.98",
+		50000:    "500.00",
+		-505:     "-5.05",
+		-1 << 63: "-92233720368547758.08",
+	} {
+		if got := a.String(); got != want {
+			t.Errorf("Amount(%d).String() = %q, want %q", int64(a), got, want)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var v struct {
+		A Amount `json:"a"`
+		B Amount `json:"b"`
+	}
+	// 0.1 + 0.2 is exactly 0.3 here
+	if err := json.Unmarshal([]byte(`{"a": 0.1, "b": "0.2"}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.A+v.B != MustParse("0.3") {
+		t.Errorf("0.1 + 0.2 = %s", v.A+v.B)
+	}
+
+	err := json.Unmarshal([]byte(`{"a": 0.125}`), &v)
+	if !errors.Is(err, ErrPrecision) {
+		t.Errorf("decoding 0.125 = %v, want ErrPrecision", err)
+	}
+	if err = json.Unmarshal([]byte(`{"a": true}`), &v); err == nil {
+		t.Error("decoding true succeeded")
+	}
+
+	b, err := json.Marshal(map[string]any{"id": 42, "sum": Amount(72998), "order": "1.5", "list": []Amount{-5, 100}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"id":42,"list":[-0.05,1.00],"order":"1.5","sum":729.98}`; string(b) != want {
+		t.Errorf("json.Marshal() = %s, want %s", b, want)
+	}
+	if want := `{"id":42,"list":["-0.05","1.00"],"order":"1.5","sum":"729.98"}`; string(QuoteDecimals(b)) != want {
+		t.Errorf("QuoteDecimals() = %s, want %s", QuoteDecimals(b), want)
+	}
+	if q := QuoteDecimals([]byte(`{"s":"a \"1.5\" \\","n":-1}`)); string(q) != `{"s":"a \"1.5\" \\","n":-1}` {
+		t.Errorf("QuoteDecimals() changed strings: %s", q)
+	}
+}
+
+func TestMulPercent(t *testing.T) {
+	tests := []struct {
+		price, percent, want string
+	}{
+		{price: "14599.50", percent: "5", want: "729.98"}, // 729.975
+		{price: "100", percent: "7.5", want: "7.50"},
+		{price: "0.10", percent: "33.33", want: "0.03"},
+		{price: "-14599.50", percent: "5", want: "-729.98"},
+	}
+	for _, tt := range tests {
+		got, err := MustParse(tt.price).MulPercent(MustParse(tt.percent))
+		if err != nil || got != MustParse(tt.want) {
+			t.Errorf("%s * %s%% = %s, %v; want %s", tt.price, tt.percent, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package amount
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// The amounts are JSON numbers with two fractional digits by default.
+// The strict clients ask for JSON strings with the media type parameter:
+//
+//	Accept: application/json; amounts=string
+const (
+	amountsParam  = "amounts"
+	amountsString = "string"
+)
+
+// WantsStrings checks the Accept header for the amounts=string parameter.
+func WantsStrings(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			_, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+			if err == nil && params[amountsParam] == amountsString {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AsStrings is the middleware quoting the amounts of the JSON responses for the clients asking for strings.
+// The other responses (text, event streams) are passed as is.
+func AsStrings(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !WantsStrings(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		aw := &amountsWriter{ResponseWriter: w}
+		next.ServeHTTP(aw, r)
+		aw.finish()
+	})
+}
+
+// amountsWriter buffers the JSON response to quote the amounts at the end
+type amountsWriter struct {
+	http.ResponseWriter
+	decided   bool
+	buffering bool
+	status    int
+	buf       bytes.Buffer
+}
+
+func (aw *amountsWriter) decide() {
+	if aw.decided {
+		return
+	}
+	aw.decided = true
+	mediaType, _, _ := mime.ParseMediaType(aw.Header().Get("Content-Type"))
+	if mediaType == "application/json" {
+		aw.buffering = true
+		aw.Header().Set("Content-Type", "application/json; "+amountsParam+"="+amountsString)
+		aw.Header().Del("Content-Length")
+	}
+}
+
+func (aw *amountsWriter) WriteHeader(statusCode int) {
+	aw.decide()
+	if aw.buffering {
+		if aw.status == 0 {
+			aw.status = statusCode
+		}
+		return
+	}
+	aw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (aw *amountsWriter) Write(b []byte) (int, error) {
+	aw.decide()
+	if aw.buffering {
+		if aw.status == 0 {
+			aw.status = http.StatusOK
+		}
+		return aw.buf.Write(b)
+	}
+	return aw.ResponseWriter.Write(b)
+}
+
+func (aw *amountsWriter) Flush() {
+	if aw.buffering {
+		return
+	}
+	if f, ok := aw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (aw *amountsWriter) finish() {
+	if !aw.buffering {
+		return
+	}
+	aw.ResponseWriter.WriteHeader(aw.status)
+	_, _ = aw.ResponseWriter.Write(QuoteDecimals(aw.buf.Bytes()))
+}
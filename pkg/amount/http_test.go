@@ -0,0 +1,54 @@
+package amount
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAsStrings(t *testing.T) {
+	handler := AsStrings(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/text" {
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte("1.50"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]any{"id": 7, "sum": Amount(150)})
+	}))
+
+	tests := []struct {
+		name        string
+		path        string
+		accept      string
+		body        string
+		contentType string
+	}{
+		{name: "numbers by default", path: "/", body: "{\"id\":7,\"sum\":1.50}\n", contentType: "application/json"},
+		{name: "strings on request", path: "/", accept: "text/plain, application/json; amounts=string",
+			body: "{\"id\":7,\"sum\":\"1.50\"}\n", contentType: "application/json; amounts=string"},
+		{name: "not json", path: "/text", accept: "application/json; amounts=string", body: "1.50", contentType: "text/plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.body)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", ct, tt.contentType)
+			}
+			if tt.path == "/" && w.Code != http.StatusCreated {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusCreated)
+			}
+		})
+	}
+}
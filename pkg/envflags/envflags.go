@@ -32,3 +32,13 @@ func TryUseEnvInt(flagValue *int, envName string) {
 		*flagValue = v
 	}
 }
+
+func TryUseEnvBool(flagValue *bool, envName string) {
+	if env := os.Getenv(envName); env != "" {
+		v, err := strconv.ParseBool(env)
+		if err != nil {
+			log.Panicf("error trying parse ENV %s (TryUseEnvBool, error: %s)", envName, err.Error())
+		}
+		*flagValue = v
+	}
+}
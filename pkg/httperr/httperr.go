@@ -0,0 +1,207 @@
+package httperr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/zasuchilas/gophermart/pkg/amount"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Every error response is the JSON envelope
+//
+//	{"error": {"code": "not_enough_funds", "message": "...", "request_id": "..."}}
+//
+// The code is stable and meant for the programs, the message is for the people and may change.
+
+const (
+	CodeBadRequest       = "bad_request"
+	CodeValidation       = "validation_failed"
+	CodeInvalidJSON      = "invalid_json"
+	CodeInvalidAmount    = "invalid_amount"
+	CodeBodyTooLarge     = "body_too_large"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeConflict         = "conflict"
+	CodeTooManyRequests  = "too_many_requests"
+	CodeInternal         = "internal_error"
+)
+
+// DefaultMaxBodySize limits the request bodies by default
+const DefaultMaxBodySize = 1 << 20
+
+var ErrEmptyBody = New(http.StatusBadRequest, CodeInvalidJSON, "the request body is empty")
+
+type Error struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func New(status int, code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+type envelope struct {
+	Error body `json:"error"`
+}
+
+type body struct {
+	*Error
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Write responds with the error envelope
+func Write(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	WriteError(w, r, New(status, code, message))
+}
+
+// WriteError responds with the envelope of the *Error, the other errors are not shown to the client
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = New(http.StatusInternalServerError, CodeInternal, "internal server error")
+	}
+	if e.Message == "" {
+		e = New(e.Status, e.Code, strings.ToLower(http.StatusText(e.Status)))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	_ = json.NewEncoder(w).Encode(envelope{Error: body{Error: e, RequestID: middleware.GetReqID(r.Context())}})
+}
+
+// Status responds with the generic code of the status
+func Status(w http.ResponseWriter, r *http.Request, status int) {
+	Write(w, r, status, statusCode(status), "")
+}
+
+func statusCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusRequestEntityTooLarge:
+		return CodeBodyTooLarge
+	case http.StatusTooManyRequests:
+		return CodeTooManyRequests
+	}
+	if status >= http.StatusInternalServerError {
+		return CodeInternal
+	}
+	return CodeBadRequest
+}
+
+// NotFound and MethodNotAllowed are the handlers of the router
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	Status(w, r, http.StatusNotFound)
+}
+
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	Status(w, r, http.StatusMethodNotAllowed)
+}
+
+// RequestID is middleware.RequestID returning the id in the X-Request-Id header as well
+func RequestID(next http.Handler) http.Handler {
+	return middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(middleware.RequestIDHeader, middleware.GetReqID(r.Context()))
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// LimitBody limits the request bodies, reading over the limit fails with *http.MaxBytesError
+func LimitBody(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ReadError converts the error of reading the request body
+func ReadError(err error) *Error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return New(http.StatusRequestEntityTooLarge, CodeBodyTooLarge,
+			fmt.Sprintf("the request body is larger than %d bytes", maxBytesErr.Limit))
+	}
+	return New(http.StatusBadRequest, CodeBadRequest, "cannot read the request body")
+}
+
+// Decode strictly decodes the JSON request body: the unknown fields and the data after the value are rejected.
+// The error is *Error.
+func Decode(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return DecodeError(err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return ReadError(err)
+		}
+		return New(http.StatusBadRequest, CodeInvalidJSON, "the request body must contain a single JSON value")
+	}
+	return nil
+}
+
+// DecodeOptional is Decode accepting the empty body
+func DecodeOptional(r *http.Request, v any) error {
+	if err := Decode(r, v); !errors.Is(err, ErrEmptyBody) {
+		return err
+	}
+	return nil
+}
+
+// DecodeError converts the error of decoding the JSON request body
+func DecodeError(err error) *Error {
+	var (
+		syntaxErr    *json.SyntaxError
+		typeErr      *json.UnmarshalTypeError
+		maxBytesErr  *http.MaxBytesError
+		invalidError = func(message string) *Error {
+			return New(http.StatusBadRequest, CodeInvalidJSON, message)
+		}
+	)
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return ReadError(err)
+	case amount.IsInvalid(err):
+		return New(http.StatusBadRequest, CodeInvalidAmount, err.Error())
+	case errors.Is(err, io.EOF):
+		return ErrEmptyBody
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return invalidError("the request body is truncated JSON")
+	case errors.As(err, &syntaxErr):
+		return invalidError(fmt.Sprintf("malformed JSON at offset %d", syntaxErr.Offset))
+	case errors.As(err, &typeErr):
+		if typeErr.Field != "" {
+			return invalidError(fmt.Sprintf("the %s field must be %s", typeErr.Field, typeErr.Type))
+		}
+		return invalidError(fmt.Sprintf("the request body must be %s", typeErr.Type))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return invalidError("unknown field " + strings.TrimPrefix(err.Error(), "json: unknown field "))
+	}
+	return invalidError("cannot decode request JSON body")
+}
@@ -0,0 +1,124 @@
+package httperr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/zasuchilas/gophermart/pkg/amount"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	type request struct {
+		Login string        `json:"login"`
+		Sum   amount.Amount `json:"sum"`
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		limit  int64
+		status int
+		code   string
+	}{
+		{"valid", `{"login": "gopher", "sum": 10.5}`, DefaultMaxBodySize, 0, ""},
+		{"unknown field", `{"login": "gopher", "password": "secret"}`, DefaultMaxBodySize, http.StatusBadRequest, CodeInvalidJSON},
+		{"malformed", `{"login": "gopher",}`, DefaultMaxBodySize, http.StatusBadRequest, CodeInvalidJSON},
+		{"truncated", `{"login": "gopher"`, DefaultMaxBodySize, http.StatusBadRequest, CodeInvalidJSON},
+		{"wrong type", `{"login": 42}`, DefaultMaxBodySize, http.StatusBadRequest, CodeInvalidJSON},
+		{"two values", `{"login": "gopher"} {"login": "gopher"}`, DefaultMaxBodySize, http.StatusBadRequest, CodeInvalidJSON},
+		{"empty", ``, DefaultMaxBodySize, http.StatusBadRequest, CodeInvalidJSON},
+		{"invalid amount", `{"sum": 10.505}`, DefaultMaxBodySize, http.StatusBadRequest, CodeInvalidAmount},
+		{"too large", `{"login": "` + strings.Repeat("g", 100) + `"}`, 64, http.StatusRequestEntityTooLarge, CodeBodyTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			r.Body = http.MaxBytesReader(httptest.NewRecorder(), r.Body, tt.limit)
+
+			var req request
+			err := Decode(r, &req)
+			if tt.code == "" {
+				if err != nil {
+					t.Fatalf("Decode() error = %v", err)
+				}
+				return
+			}
+			var e *Error
+			if !errors.As(err, &e) {
+				t.Fatalf("Decode() error = %v, want *Error", err)
+			}
+			if e.Status != tt.status || e.Code != tt.code {
+				t.Errorf("Decode() error = %d %s, want %d %s", e.Status, e.Code, tt.status, tt.code)
+			}
+		})
+	}
+}
+
+func TestDecodeOptional(t *testing.T) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(""))
+	if err := DecodeOptional(r, &req); err != nil {
+		t.Errorf("DecodeOptional() of the empty body error = %v", err)
+	}
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{"))
+	if err := DecodeOptional(r, &req); err == nil {
+		t.Error("DecodeOptional() of the malformed body error = nil")
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		status  int
+		code    string
+		message string
+	}{
+		{"api error", New(http.StatusPaymentRequired, "not_enough_funds", "not enough funds"),
+			http.StatusPaymentRequired, "not_enough_funds", "not enough funds"},
+		{"wrapped api error", fmt.Errorf("withdrawing: %w", New(http.StatusConflict, CodeConflict, "conflict")),
+			http.StatusConflict, CodeConflict, "conflict"},
+		{"other error is hidden", errors.New("pq: connection refused"),
+			http.StatusInternalServerError, CodeInternal, "internal server error"},
+		{"status text", New(http.StatusNotFound, CodeNotFound, ""),
+			http.StatusNotFound, CodeNotFound, "not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				WriteError(w, r, tt.err)
+			}))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			var resp struct {
+				Error struct {
+					Code      string `json:"code"`
+					Message   string `json:"message"`
+					RequestID string `json:"request_id"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decoding envelope %q: %v", w.Body.String(), err)
+			}
+			if resp.Error.Code != tt.code || resp.Error.Message != tt.message {
+				t.Errorf("error = %+v, want %s %q", resp.Error, tt.code, tt.message)
+			}
+			if resp.Error.RequestID == "" || resp.Error.RequestID != w.Header().Get("X-Request-Id") {
+				t.Errorf("request_id = %q, X-Request-Id = %q", resp.Error.RequestID, w.Header().Get("X-Request-Id"))
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+// Package openapi serves the OpenAPI 3 document of a service and validates the requests against it.
+// The validation covers the subset of the schemas the documents use: types, enums, required
+// and additional properties, lengths, ranges, patterns, date-time strings, items, oneOf and $ref.
+package openapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var methods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions, http.MethodTrace,
+}
+
+// Spec is the parsed OpenAPI document
+type Spec struct {
+	raw        []byte
+	components components
+	operations []*operation
+}
+
+type document struct {
+	OpenAPI    string                                `json:"openapi"`
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components components                            `json:"components"`
+}
+
+type components struct {
+	Schemas    map[string]*Schema    `json:"schemas"`
+	Parameters map[string]*Parameter `json:"parameters"`
+}
+
+type Operation struct {
+	OperationID string       `json:"operationId"`
+	Parameters  []*Parameter `json:"parameters"`
+	RequestBody *RequestBody `json:"requestBody"`
+}
+
+type Parameter struct {
+	Ref      string  `json:"$ref"`
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Nullable             bool               `json:"nullable"`
+	Enum                 []any              `json:"enum"`
+	OneOf                []*Schema          `json:"oneOf"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Pattern              string             `json:"pattern"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	MinItems             *int               `json:"minItems"`
+	MaxItems             *int               `json:"maxItems"`
+
+	pattern *regexp.Regexp
+}
+
+// operation is the operation with its path template split into segments
+type operation struct {
+	*Operation
+	method   string
+	path     string
+	segments []string
+}
+
+// Parse parses the JSON document, the patterns and the references are checked
+func Parse(raw []byte) (*Spec, error) {
+	var doc document
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("decoding openapi document: %w", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, fmt.Errorf("unsupported openapi version %q", doc.OpenAPI)
+	}
+
+	s := &Spec{raw: raw, components: doc.Components}
+	for path, item := range doc.Paths {
+		for _, method := range methods {
+			rawOp, ok := item[strings.ToLower(method)]
+			if !ok {
+				continue
+			}
+			var op Operation
+			if err := json.Unmarshal(rawOp, &op); err != nil {
+				return nil, fmt.Errorf("decoding %s %s: %w", method, path, err)
+			}
+			s.operations = append(s.operations, &operation{
+				Operation: &op,
+				method:    method,
+				path:      path,
+				segments:  strings.Split(strings.Trim(path, "/"), "/"),
+			})
+		}
+	}
+	sort.Slice(s.operations, func(i, j int) bool {
+		if s.operations[i].path != s.operations[j].path {
+			return s.operations[i].path < s.operations[j].path
+		}
+		return s.operations[i].method < s.operations[j].method
+	})
+
+	if err := s.compile(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MustParse is Parse for the embedded documents, it panics on the error
+func MustParse(raw []byte) *Spec {
+	s, err := Parse(raw)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// compile resolves the parameter references and compiles the patterns
+func (s *Spec) compile() error {
+	for _, schema := range s.components.Schemas {
+		if err := s.compileSchema(schema); err != nil {
+			return err
+		}
+	}
+	for _, op := range s.operations {
+		for i, p := range op.Parameters {
+			if p.Ref != "" {
+				name := strings.TrimPrefix(p.Ref, "#/components/parameters/")
+				ref, ok := s.components.Parameters[name]
+				if !ok {
+					return fmt.Errorf("%s %s: unknown parameter %s", op.method, op.path, p.Ref)
+				}
+				op.Parameters[i], p = ref, ref
+			}
+			if err := s.compileSchema(p.Schema); err != nil {
+				return fmt.Errorf("%s %s: %w", op.method, op.path, err)
+			}
+		}
+		if op.RequestBody != nil {
+			for _, media := range op.RequestBody.Content {
+				if err := s.compileSchema(media.Schema); err != nil {
+					return fmt.Errorf("%s %s: %w", op.method, op.path, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Spec) compileSchema(schema *Schema) error {
+	if schema == nil {
+		return nil
+	}
+	if schema.Ref != "" {
+		if _, err := s.resolve(schema); err != nil {
+			return err
+		}
+		return nil
+	}
+	if schema.Pattern != "" && schema.pattern == nil {
+		re, err := regexp.Compile(schema.Pattern)
+		if err != nil {
+			return fmt.Errorf("pattern %q: %w", schema.Pattern, err)
+		}
+		schema.pattern = re
+	}
+	for _, sub := range schema.OneOf {
+		if err := s.compileSchema(sub); err != nil {
+			return err
+		}
+	}
+	for _, prop := range schema.Properties {
+		if err := s.compileSchema(prop); err != nil {
+			return err
+		}
+	}
+	return s.compileSchema(schema.Items)
+}
+
+// resolve returns the component schema of the reference
+func (s *Spec) resolve(schema *Schema) (*Schema, error) {
+	for schema.Ref != "" {
+		name := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+		ref, ok := s.components.Schemas[name]
+		if !ok {
+			return nil, errors.New("unknown schema " + schema.Ref)
+		}
+		schema = ref
+	}
+	return schema, nil
+}
+
+// ServeHTTP serves the document
+func (s *Spec) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	_, _ = w.Write(s.raw)
+}
+
+// Routes lists the operations as "METHOD /path/{param}" sorted by the path
+func (s *Spec) Routes() []string {
+	routes := make([]string, len(s.operations))
+	for i, op := range s.operations {
+		routes[i] = op.method + " " + op.path
+	}
+	return routes
+}
+
+// find returns the operation of the request and its path parameters,
+// the literal segments win over the parameters like the router does
+func (s *Spec) find(r *http.Request) (*operation, map[string]string) {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	var (
+		found       *operation
+		bestLiteral = -1
+	)
+	for _, op := range s.operations {
+		if op.method != r.Method || len(op.segments) != len(segments) {
+			continue
+		}
+		literal, ok := 0, true
+		for i, seg := range op.segments {
+			switch {
+			case isParam(seg):
+			case seg == segments[i]:
+				literal++
+			default:
+				ok = false
+			}
+			if !ok {
+				break
+			}
+		}
+		if ok && literal > bestLiteral {
+			found, bestLiteral = op, literal
+		}
+	}
+	if found == nil {
+		return nil, nil
+	}
+
+	params := make(map[string]string)
+	for i, seg := range found.segments {
+		if isParam(seg) {
+			params[seg[1:len(seg)-1]] = segments[i]
+		}
+	}
+	return found, params
+}
+
+func isParam(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
@@ -0,0 +1,182 @@
+package openapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testDocument = `{
+  "openapi": "3.0.3",
+  "info": {"title": "test", "version": "1"},
+  "paths": {
+    "/items": {
+      "get": {
+        "parameters": [
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 100}},
+          {"name": "from", "in": "query", "schema": {"type": "string", "format": "date-time"}}
+        ],
+        "responses": {"200": {"description": "ok"}}
+      },
+      "post": {
+        "parameters": [{"$ref": "#/components/parameters/IdempotencyKey"}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Item"}}}
+        },
+        "responses": {"201": {"description": "created"}}
+      }
+    },
+    "/items/batch": {
+      "post": {
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {"schema": {"type": "array", "minItems": 1, "items": {"type": "string"}}},
+            "text/plain": {"schema": {"type": "string"}}
+          }
+        },
+        "responses": {"200": {"description": "ok"}}
+      }
+    },
+    "/items/{id}": {
+      "get": {
+        "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "minimum": 1}}],
+        "responses": {"200": {"description": "ok"}}
+      }
+    }
+  },
+  "components": {
+    "parameters": {
+      "IdempotencyKey": {"name": "Idempotency-Key", "in": "header", "schema": {"type": "string", "maxLength": 8}}
+    },
+    "schemas": {
+      "Amount": {"oneOf": [{"type": "number"}, {"type": "string", "pattern": "^[0-9]+(\\.[0-9]+)?$"}]},
+      "Item": {
+        "type": "object",
+        "required": ["name", "price"],
+        "properties": {
+          "name": {"type": "string", "minLength": 3},
+          "price": {"$ref": "#/components/schemas/Amount"},
+          "kind": {"type": "string", "enum": ["food", "tool"]},
+          "size": {"type": "number", "enum": [1, 2.5]},
+          "tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2}
+        },
+        "additionalProperties": false
+      }
+    }
+  }
+}`
+
+func TestRoutes(t *testing.T) {
+	s := MustParse([]byte(testDocument))
+	want := []string{"GET /items", "POST /items", "POST /items/batch", "GET /items/{id}"}
+	got := s.Routes()
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("Routes() = %v, want %v", got, want)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"swagger", `{"swagger": "2.0", "paths": {}}`},
+		{"unknown schema", `{"openapi": "3.0.3", "paths": {"/": {"post": {"requestBody": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Nope"}}}}}}}}`},
+		{"unknown parameter", `{"openapi": "3.0.3", "paths": {"/": {"get": {"parameters": [{"$ref": "#/components/parameters/Nope"}]}}}}`},
+		{"bad pattern", `{"openapi": "3.0.3", "paths": {}, "components": {"schemas": {"A": {"type": "string", "pattern": "("}}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tt.doc)); err == nil {
+				t.Error("Parse() error = nil")
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	s := MustParse([]byte(testDocument))
+	// echoes the body to check it is restored after the validation
+	h := s.Validate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	}))
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		header      string
+		body        string
+		wantErr     string // the part of the error message, empty for the valid requests
+	}{
+		{"valid body", http.MethodPost, "/items", "application/json", "", `{"name": "apple", "price": 10.5, "kind": "food"}`, ""},
+		{"amount string", http.MethodPost, "/items", "application/json", "", `{"name": "apple", "price": "10.50"}`, ""},
+		{"missing property", http.MethodPost, "/items", "application/json", "", `{"name": "apple"}`, "body.price is required"},
+		{"unknown property", http.MethodPost, "/items", "application/json", "", `{"name": "apple", "price": 1, "color": "red"}`, "body.color is unknown"},
+		{"short string", http.MethodPost, "/items", "application/json", "", `{"name": "ap", "price": 1}`, "body.name must be at least 3 characters"},
+		{"wrong type", http.MethodPost, "/items", "application/json", "", `{"name": 42, "price": 1}`, "body.name must be a string"},
+		{"no oneOf", http.MethodPost, "/items", "application/json", "", `{"name": "apple", "price": true}`, "body.price must match exactly one"},
+		{"enum", http.MethodPost, "/items", "application/json", "", `{"name": "apple", "price": 1, "kind": "car"}`, "body.kind must be one of"},
+		{"number enum", http.MethodPost, "/items", "application/json", "", `{"name": "apple", "price": 1, "size": 1.0}`, ""},
+		{"number enum exponent", http.MethodPost, "/items", "application/json", "", `{"name": "apple", "price": 1, "size": 25e-1}`, ""},
+		{"number not in enum", http.MethodPost, "/items", "application/json", "", `{"name": "apple", "price": 1, "size": 1.01}`, "body.size must be one of"},
+		{"too many items", http.MethodPost, "/items", "application/json", "", `{"name": "apple", "price": 1, "tags": ["a", "b", "c"]}`, "body.tags must have at most 2 items"},
+		{"array item", http.MethodPost, "/items", "application/json", "", `{"name": "apple", "price": 1, "tags": ["a", 1]}`, "body.tags[1] must be a string"},
+		{"empty body", http.MethodPost, "/items", "application/json", "", ``, "the request body is empty"},
+		{"malformed body", http.MethodPost, "/items", "application/json", "", `{"name":`, "truncated"},
+		{"long header", http.MethodPost, "/items", "application/json", "0123456789", `{"name": "apple", "price": 1}`, "header parameter Idempotency-Key must be at most 8"},
+		{"text batch", http.MethodPost, "/items/batch", "text/plain", "", "1\n2", ""},
+		{"json batch", http.MethodPost, "/items/batch", "application/json", "", `[]`, "body must have at least 1 items"},
+		{"valid query", http.MethodGet, "/items?limit=10&from=2024-01-02T15:04:05Z", "", "", "", ""},
+		{"query range", http.MethodGet, "/items?limit=1000", "", "", "", "query parameter limit must be at most 100"},
+		{"query type", http.MethodGet, "/items?limit=ten", "", "", "", "query parameter limit must be an integer"},
+		{"query format", http.MethodGet, "/items?from=yesterday", "", "", "", "query parameter from must be a RFC3339 time"},
+		{"valid path", http.MethodGet, "/items/7", "", "", "", ""},
+		{"path type", http.MethodGet, "/items/seven", "", "", "", "path parameter id must be an integer"},
+		{"unknown route", http.MethodDelete, "/items/7", "", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.header != "" {
+				req.Header.Set("Idempotency-Key", tt.header)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if tt.wantErr == "" {
+				if w.Code != http.StatusOK {
+					t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+				}
+				if w.Body.String() != tt.body {
+					t.Errorf("the body is not restored: %q", w.Body.String())
+				}
+				return
+			}
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			var resp struct {
+				Error struct {
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decoding envelope %q: %v", w.Body.String(), err)
+			}
+			if !strings.Contains(resp.Error.Message, tt.wantErr) {
+				t.Errorf("message = %q, want %q", resp.Error.Message, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/zasuchilas/gophermart/pkg/httperr"
+	"io"
+	"math/big"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// the JSON number grammar for the numeric parameters
+var numberRe = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// Validate is the middleware rejecting the requests not matching the document with 400.
+// The requests of the unknown routes are passed on to the router responding with 404 or 405.
+// The JSON bodies are validated against their schemas, the other media types are left to the handlers.
+func (s *Spec) Validate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := s.ValidateRequest(r); err != nil {
+			httperr.WriteError(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ValidateRequest checks the parameters and the body of the request, the body is read and restored.
+// The error is *httperr.Error.
+func (s *Spec) ValidateRequest(r *http.Request) error {
+	op, pathParams := s.find(r)
+	if op == nil {
+		return nil
+	}
+
+	for _, p := range op.Parameters {
+		var (
+			value   string
+			present bool
+		)
+		switch p.In {
+		case "path":
+			value, present = pathParams[p.Name]
+		case "query":
+			values := r.URL.Query()
+			value, present = values.Get(p.Name), values.Has(p.Name)
+		case "header":
+			value = r.Header.Get(p.Name)
+			present = value != ""
+		default:
+			continue
+		}
+		if !present {
+			if p.Required {
+				return invalid(fmt.Sprintf("the %s parameter %s is required", p.In, p.Name))
+			}
+			continue
+		}
+		if err := s.validateParam(p, value); err != nil {
+			return invalid(fmt.Sprintf("the %s parameter %s", p.In, err.Error()))
+		}
+	}
+
+	if op.RequestBody != nil {
+		return s.validateBody(r, op.RequestBody)
+	}
+	return nil
+}
+
+func (s *Spec) validateParam(p *Parameter, value string) error {
+	schema, err := s.resolve(p.Schema)
+	if err != nil || schema == nil {
+		return err
+	}
+	var v any = value
+	switch schema.Type {
+	case "integer", "number":
+		if !numberRe.MatchString(value) {
+			return fmt.Errorf("%s must be %s", p.Name, article(schema.Type))
+		}
+		v = json.Number(value)
+	case "boolean":
+		b, er := strconv.ParseBool(value)
+		if er != nil {
+			return fmt.Errorf("%s must be a boolean", p.Name)
+		}
+		v = b
+	}
+	return s.validate(p.Name, v, p.Schema)
+}
+
+func (s *Spec) validateBody(r *http.Request, rb *RequestBody) error {
+	media, ok := rb.Content["application/json"]
+	if !ok {
+		return nil
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" && len(rb.Content) > 1 {
+		return nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return httperr.ReadError(err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		if rb.Required {
+			return httperr.ErrEmptyBody
+		}
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err = dec.Decode(&v); err != nil {
+		return httperr.DecodeError(err)
+	}
+	if media.Schema == nil {
+		return nil
+	}
+	if err = s.validate("body", v, media.Schema); err != nil {
+		return invalid("the " + err.Error())
+	}
+	return nil
+}
+
+// validate checks the decoded JSON value, the error names the path of the wrong value
+func (s *Spec) validate(path string, v any, schema *Schema) error {
+	schema, err := s.resolve(schema)
+	if err != nil || schema == nil {
+		return err
+	}
+	if v == nil {
+		if schema.Nullable || (schema.Type == "" && len(schema.OneOf) == 0) {
+			return nil
+		}
+		return fmt.Errorf("%s must not be null", path)
+	}
+
+	if len(schema.OneOf) > 0 {
+		matched := 0
+		for _, sub := range schema.OneOf {
+			if s.validate(path, v, sub) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return fmt.Errorf("%s must match exactly one of %d schemas", path, len(schema.OneOf))
+		}
+	}
+
+	if len(schema.Enum) > 0 && !slices.ContainsFunc(schema.Enum, func(e any) bool { return equal(e, v) }) {
+		return fmt.Errorf("%s must be one of %v", path, schema.Enum)
+	}
+
+	switch schema.Type {
+	case "":
+		return nil
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return typeError(path, schema.Type)
+		}
+		return s.validateObject(path, obj, schema)
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			return typeError(path, schema.Type)
+		}
+		if schema.MinItems != nil && len(arr) < *schema.MinItems {
+			return fmt.Errorf("%s must have at least %d items", path, *schema.MinItems)
+		}
+		if schema.MaxItems != nil && len(arr) > *schema.MaxItems {
+			return fmt.Errorf("%s must have at most %d items", path, *schema.MaxItems)
+		}
+		for i, item := range arr {
+			if err = s.validate(fmt.Sprintf("%s[%d]", path, i), item, schema.Items); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return typeError(path, schema.Type)
+		}
+		return validateString(path, str, schema)
+	case "integer", "number":
+		num, ok := v.(json.Number)
+		if !ok {
+			return typeError(path, schema.Type)
+		}
+		return validateNumber(path, num, schema)
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return typeError(path, schema.Type)
+		}
+	}
+	return nil
+}
+
+func (s *Spec) validateObject(path string, obj map[string]any, schema *Schema) error {
+	for _, name := range schema.Required {
+		if _, ok := obj[name]; !ok {
+			return fmt.Errorf("%s.%s is required", path, name)
+		}
+	}
+	closed := string(schema.AdditionalProperties) == "false"
+	for name, value := range obj {
+		prop, ok := schema.Properties[name]
+		if !ok {
+			if closed {
+				return fmt.Errorf("%s.%s is unknown", path, name)
+			}
+			continue
+		}
+		if err := s.validate(path+"."+name, value, prop); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateString(path, str string, schema *Schema) error {
+	n := utf8.RuneCountInString(str)
+	if schema.MinLength != nil && n < *schema.MinLength {
+		return fmt.Errorf("%s must be at least %d characters", path, *schema.MinLength)
+	}
+	if schema.MaxLength != nil && n > *schema.MaxLength {
+		return fmt.Errorf("%s must be at most %d characters", path, *schema.MaxLength)
+	}
+	if schema.pattern != nil && !schema.pattern.MatchString(str) {
+		return fmt.Errorf("%s must match %s", path, schema.Pattern)
+	}
+	if schema.Format == "date-time" {
+		if _, err := time.Parse(time.RFC3339, str); err != nil {
+			return fmt.Errorf("%s must be a RFC3339 time", path)
+		}
+	}
+	return nil
+}
+
+func validateNumber(path string, num json.Number, schema *Schema) error {
+	r, ok := new(big.Rat).SetString(num.String())
+	if !ok {
+		return typeError(path, schema.Type)
+	}
+	if schema.Type == "integer" && !r.IsInt() {
+		return typeError(path, schema.Type)
+	}
+	if schema.Minimum != nil && r.Cmp(new(big.Rat).SetFloat64(*schema.Minimum)) < 0 {
+		return fmt.Errorf("%s must be at least %v", path, *schema.Minimum)
+	}
+	if schema.Maximum != nil && r.Cmp(new(big.Rat).SetFloat64(*schema.Maximum)) > 0 {
+		return fmt.Errorf("%s must be at most %v", path, *schema.Maximum)
+	}
+	return nil
+}
+
+// equal compares the enum value of the document with the value of the request,
+// the numbers are compared by value, so 1.0 equals 1
+func equal(e, v any) bool {
+	if num, ok := v.(json.Number); ok {
+		f, ok := e.(float64)
+		if !ok {
+			return false
+		}
+		r, ok := new(big.Rat).SetString(num.String())
+		return ok && r.Cmp(new(big.Rat).SetFloat64(f)) == 0
+	}
+	return e == v
+}
+
+func typeError(path, typ string) error {
+	return fmt.Errorf("%s must be %s", path, article(typ))
+}
+
+func article(typ string) string {
+	switch typ {
+	case "integer", "object", "array":
+		return "an " + typ
+	}
+	return "a " + typ
+}
+
+func invalid(message string) error {
+	return httperr.New(http.StatusBadRequest, httperr.CodeValidation, message)
+}
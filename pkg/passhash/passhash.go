@@ -1,13 +1,160 @@
 package passhash
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+// The hashes describe themselves: argon2id is stored in the PHC string format
+// $argon2id$v=19$m=<memory KiB>,t=<time>,p=<threads>$<salt>$<key> and bcrypt in its own $2a$<cost>$ format,
+// so the hashes made with other parameters are still checked and can be upgraded by NeedsRehash.
+
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+var ErrUnknownAlgorithm = errors.New("unknown password hash algorithm")
+
+type Params struct {
+	Algorithm string
+	Time      uint32 // argon2id passes
+	Memory    uint32 // argon2id memory in KiB
+	Threads   uint8  // argon2id parallelism
+	Cost      int    // bcrypt cost
+}
+
+// DefaultParams are the argon2id minimum of the OWASP password storage cheat sheet
+var DefaultParams = Params{
+	Algorithm: Argon2id,
+	Time:      2,
+	Memory:    19 * 1024,
+	Threads:   1,
+	Cost:      10,
+}
+
+var params = DefaultParams
+
+// SetParams changes the parameters of the new hashes
+func SetParams(p Params) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	params = p
+	return nil
+}
+
+func (p Params) validate() error {
+	switch p.Algorithm {
+	case Argon2id:
+		if p.Time < 1 || p.Memory < 8*uint32(p.Threads) || p.Threads < 1 {
+			return fmt.Errorf("invalid argon2id parameters (t=%d, m=%d, p=%d)", p.Time, p.Memory, p.Threads)
+		}
+	case Bcrypt:
+		if p.Cost < bcrypt.MinCost || p.Cost > bcrypt.MaxCost {
+			return fmt.Errorf("invalid bcrypt cost %d", p.Cost)
+		}
+	default:
+		return ErrUnknownAlgorithm
+	}
+	return nil
+}
 
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
+	return params.Hash(password)
+}
+
+func (p Params) Hash(password string) (string, error) {
+	switch p.Algorithm {
+	case Argon2id:
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, argon2KeyLen)
+		return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", Argon2id, argon2.Version, p.Memory, p.Time, p.Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	case Bcrypt:
+		bytes, err := bcrypt.GenerateFromPassword([]byte(password), p.Cost)
+		return string(bytes), err
+	}
+	return "", ErrUnknownAlgorithm
 }
 
 func CheckPasswordHash(password, hash string) bool {
+	if strings.HasPrefix(hash, "$"+Argon2id+"$") {
+		h, err := parseArgon2(hash)
+		if err != nil {
+			return false
+		}
+		key := argon2.IDKey([]byte(password), h.salt, h.params.Time, h.params.Memory, h.params.Threads, uint32(len(h.key)))
+		return subtle.ConstantTimeCompare(key, h.key) == 1
+	}
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
+
+// NeedsRehash reports whether the hash is made with other algorithm or parameters than the current ones
+func NeedsRehash(hash string) bool {
+	switch params.Algorithm {
+	case Argon2id:
+		h, err := parseArgon2(hash)
+		if err != nil {
+			return true
+		}
+		return h.params != params || len(h.salt) != argon2SaltLen || len(h.key) != argon2KeyLen
+	case Bcrypt:
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != params.Cost
+	}
+	return false
+}
+
+type argon2Hash struct {
+	params Params
+	salt   []byte
+	key    []byte
+}
+
+func parseArgon2(hash string) (*argon2Hash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != Argon2id {
+		return nil, errors.New("not an argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, err
+	}
+	if version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	h := &argon2Hash{params: Params{Algorithm: Argon2id, Cost: params.Cost}}
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.params.Memory, &h.params.Time, &h.params.Threads)
+	if err != nil {
+		return nil, err
+	}
+	if err = h.params.validate(); err != nil {
+		return nil, err
+	}
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, err
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, err
+	}
+	if len(h.key) == 0 {
+		return nil, errors.New("empty argon2id key")
+	}
+	return h, nil
+}
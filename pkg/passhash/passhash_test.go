@@ -0,0 +1,111 @@
+package passhash
+
+import (
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+)
+
+// fast parameters, the real ones are measured by the benchmarks
+var (
+	testArgon2 = Params{Algorithm: Argon2id, Time: 1, Memory: 64, Threads: 1, Cost: 10}
+	testBcrypt = Params{Algorithm: Bcrypt, Time: 1, Memory: 64, Threads: 1, Cost: bcrypt.MinCost}
+)
+
+func TestHashAndCheck(t *testing.T) {
+	for _, p := range []Params{testArgon2, testBcrypt} {
+		t.Run(p.Algorithm, func(t *testing.T) {
+			hash, err := p.Hash("password")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !CheckPasswordHash("password", hash) {
+				t.Error("the password does not match its hash")
+			}
+			if CheckPasswordHash("passwore", hash) {
+				t.Error("another password matches the hash")
+			}
+			other, _ := p.Hash("password")
+			if other == hash {
+				t.Error("the hashes of the same password are equal, the salt is not random")
+			}
+		})
+	}
+
+	hash, _ := testArgon2.Hash("password")
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("unexpected argon2id hash format %s", hash)
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	defer func() { params = DefaultParams }()
+
+	argon2Hash, _ := testArgon2.Hash("password")
+	bcryptHash, _ := testBcrypt.Hash("password")
+	stronger := testArgon2
+	stronger.Time = 2
+
+	tests := []struct {
+		name    string
+		current Params
+		hash    string
+		want    bool
+	}{
+		{"same argon2id", testArgon2, argon2Hash, false},
+		{"argon2id with other parameters", stronger, argon2Hash, true},
+		{"bcrypt to argon2id", testArgon2, bcryptHash, true},
+		{"same bcrypt", testBcrypt, bcryptHash, false},
+		{"bcrypt with other cost", Params{Algorithm: Bcrypt, Cost: 5}, bcryptHash, true},
+		{"argon2id to bcrypt", testBcrypt, argon2Hash, true},
+		{"malformed", testArgon2, "$argon2id$v=19$m=64", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SetParams(tt.current); err != nil {
+				t.Fatal(err)
+			}
+			if got := NeedsRehash(tt.hash); got != tt.want {
+				t.Errorf("NeedsRehash = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetParams(t *testing.T) {
+	defer func() { params = DefaultParams }()
+
+	for _, p := range []Params{
+		{Algorithm: "md5"},
+		{Algorithm: Argon2id, Time: 0, Memory: 64, Threads: 1},
+		{Algorithm: Argon2id, Time: 1, Memory: 64, Threads: 0},
+		{Algorithm: Bcrypt, Cost: 32},
+	} {
+		if err := SetParams(p); err == nil {
+			t.Errorf("SetParams(%+v) accepted invalid parameters", p)
+		}
+	}
+}
+
+func BenchmarkHash(b *testing.B) {
+	benchmarks := []struct {
+		name string
+		p    Params
+	}{
+		{"argon2id/default", DefaultParams},
+		{"argon2id/t=1,m=64MiB,p=4", Params{Algorithm: Argon2id, Time: 1, Memory: 64 * 1024, Threads: 4}},
+		{"argon2id/t=3,m=64MiB,p=4", Params{Algorithm: Argon2id, Time: 3, Memory: 64 * 1024, Threads: 4}},
+		{"bcrypt/cost=10", Params{Algorithm: Bcrypt, Cost: 10}},
+		{"bcrypt/cost=12", Params{Algorithm: Bcrypt, Cost: 12}},
+		{"bcrypt/cost=14", Params{Algorithm: Bcrypt, Cost: 14}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := bm.p.Hash("correct horse battery staple"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}